@@ -0,0 +1,7 @@
+@echo off
+
+set "BIN_NAME=gzed"
+set "CODE_HOME=%~dp0.."
+set "CODE_BIN=%CODE_HOME%\gcode.exe"
+
+"%CODE_BIN%" %BIN_NAME% %*
\ No newline at end of file
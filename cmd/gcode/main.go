@@ -8,57 +8,55 @@ import (
 	"runtime"
 	"strings"
 
-	"github.com/samber/lo"
 	"github.com/xingty/rcode-go/gcode/code"
 	"github.com/xingty/rcode-go/gcode/config"
+	"github.com/xingty/rcode-go/pkg/models"
 )
 
-var COMMANDS map[string]string = map[string]string{
-	"gcode":     "code",
-	"gcursor":   "cursor",
-	"gwindsurf": "windsurf",
-	"gtrae":     "trae",
-}
-
 var version = "0.0.10"
 
 func main() {
 	config.InitGCodeEnv()
-	args := os.Args[1:]
-	if len(args) == 0 {
-		flag.Usage()
+	config.InitLogger("gcode")
+	editors, err := config.LoadEditors(config.GCODE_EDITORS_FILE)
+	if err != nil {
+		fmt.Println(err.Error())
 		os.Exit(1)
 	}
 
-	binName, ok := COMMANDS[args[0]]
+	// the wrappers pass their name as the first arg, a symlink to gcode is
+	// recognized by its own name
+	args := os.Args[1:]
+	editor, ok := editors.ByAlias(os.Args[0])
+	if len(args) > 0 {
+		if named, found := editors.ByAlias(args[0]); found {
+			editor, ok = named, true
+			args = args[1:]
+		}
+	}
+
 	if !ok {
-		fmt.Printf("unknown command: %s\n", binName)
+		fmt.Printf("unknown command: %s\n", filepath.Base(os.Args[0]))
 		os.Exit(1)
 	}
 
 	flag.Usage = func() {
-		keys := strings.Join(lo.Keys(COMMANDS), " | ")
+		keys := strings.Join(editors.Aliases(), " | ")
 
 		fmt.Println("Usage:")
 		fmt.Printf("Run on local:  [%s] <host> <dir> [options]\n", keys)
-		fmt.Printf("Run on remote: [%s] <dir> \n", keys)
+		fmt.Printf("Run on remote: [%s] <dir>... | <file>... [-root] [-ws <name>] [-devcontainer]\n", keys)
+		fmt.Printf("               [%s] -g <file:line[:col]> | -d <file> <file> | -m <file> <file> <base> <result>\n", keys)
+		fmt.Printf("Diff:          [%s] diff <file> --local <path> (remote) | diff <host>:<path> <path> (local)\n", keys)
+		fmt.Printf("Workspaces:    [%s] workspaces | -ws <name> (local)\n", keys)
+		fmt.Printf("Clean sockets: [%s] gc\n", keys)
+		fmt.Printf("Port forward:  [%s] forward add|list|rm <port> (remote, gssh -control)\n", keys)
 		fmt.Println("Just gcode 'file' like your VSCode 'code' .")
 		fmt.Println("\nOptions:")
 		flag.PrintDefaults()
 	}
 
-	args = args[1:]
-	commands := make([]string, 0)
-	for index, arg := range args {
-		if strings.HasPrefix(arg, "-") {
-			args = args[index:]
-			break
-		}
-
-		commands = append(commands, arg)
-	}
-
-	isRemote, err := code.IsRemote(binName)
+	isRemote, err := code.IsRemote()
 	if err != nil {
 		fmt.Println(err.Error())
 		os.Exit(1)
@@ -68,24 +66,105 @@ func main() {
 	isLatest := flag.Bool("l", false, "if is_latest")
 	shortcutName := flag.String("sn", "latest", "open shortcut name")
 	openShortcut := flag.String("os", "", "open shortcut")
-	flag.CommandLine.Parse(args)
+
+	// VS Code options, passed on by remote gcode
+	options := models.OpenOptions{}
+	flag.BoolVar(&options.Goto, "g", false, "Open a file at file:line[:col]")
+	flag.BoolVar(&options.Diff, "d", false, "Compare two files")
+	flag.BoolVar(&options.Merge, "m", false, "Perform a three-way merge: path1 path2 base result")
+	flag.BoolVar(&options.NewWindow, "n", false, "Force to open a new window")
+	flag.BoolVar(&options.ReuseWindow, "r", false, "Force to open in an already opened window")
+	flag.BoolVar(&options.Add, "a", false, "Add folder to the last active window")
+	flag.StringVar(&options.Profile, "profile", "", "Open with the given profile")
+	flag.BoolVar(&options.Wait, "wait", false, "Wait for the files to be closed before returning")
+	flag.BoolVar(&options.Wait, "w", false, "Shorthand for -wait")
+	withRoot := flag.Bool("root", false, "Open files in the window of their project root")
+	workspace := flag.String("ws", "", "Name of the workspace of several directories, or the workspace to open locally")
+	devcontainer := flag.Bool("devcontainer", false, "Reopen the directory in its dev container")
+	local := flag.String("local", "", "File on the local machine to compare with, relative to the home directory")
+
+	// options may come before, between or after the commands
+	commands := make([]string, 0)
+	for {
+		flag.CommandLine.Parse(args)
+		args = flag.Args()
+		if len(args) == 0 {
+			break
+		}
+
+		commands = append(commands, args[0])
+		args = args[1:]
+	}
 
 	if *v {
 		fmt.Printf("gcode version: %s %s/%s\n", version, runtime.GOOS, runtime.GOARCH)
 		os.Exit(0)
 	}
 
+	if len(commands) == 1 && isSubcommand(commands[0], "gc") {
+		removed, err := code.CleanStaleSockets(0)
+		if err != nil {
+			fmt.Printf("failed to clean sockets: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		fmt.Printf("removed %d stale socket(s)\n", removed)
+		os.Exit(0)
+	}
+
 	if isRemote {
+		code.CleanStaleSockets(code.SWEEP_LIMIT)
+
 		if len(commands) == 0 {
 			flag.Usage()
 			os.Exit(1)
 		}
 
-		dirName := commands[0]
-		dirName, _ = filepath.Abs(dirName)
-		err := code.RunRemote(binName, dirName, code.MAX_IDLE_TIME)
+		if isSubcommand(commands[0], "forward") && len(commands) >= 2 {
+			port := ""
+			if len(commands) >= 3 {
+				port = commands[2]
+			}
+
+			err := code.RunForward(commands[1], port)
+			if err != nil {
+				fmt.Printf("failed to forward: %s\n", err.Error())
+				os.Exit(1)
+			}
+
+			os.Exit(0)
+		}
+
+		// without --local, diff is a path like any other
+		if *local != "" {
+			if commands[0] != "diff" || len(commands) != 2 {
+				fmt.Println("--local is only used by diff <file> --local <path>")
+				os.Exit(1)
+			}
+
+			exitCode, err := code.RunRemoteDiff(editor, commands[1], *local, options)
+			if err != nil {
+				fmt.Printf("failed to diff: %s\n", err.Error())
+				os.Exit(1)
+			}
+
+			os.Exit(exitCode)
+		}
+
+		exitCode, err := code.RunRemote(editor, commands, options, *withRoot, *workspace, *devcontainer)
 		if err != nil {
-			fmt.Printf("failed to run %s: %s\n", binName, err.Error())
+			fmt.Printf("failed to run %s: %s\n", editor.Name, err.Error())
+			os.Exit(1)
+		}
+
+		os.Exit(exitCode)
+	}
+
+	// a host named diff is opened as usual, the remote file is host:path
+	if len(commands) == 3 && commands[0] == "diff" && strings.Contains(commands[1], ":") {
+		err := code.RunLocalDiff(editor, commands[1], commands[2], options)
+		if err != nil {
+			fmt.Printf("failed to diff: %s\n", err.Error())
 			os.Exit(1)
 		}
 
@@ -96,9 +175,27 @@ func main() {
 		hostname := commands[0]
 		dirName := commands[1]
 
-		err := code.RunLocal(binName, hostname, dirName, *shortcutName)
+		err := code.RunLocal(editor, hostname, dirName, *shortcutName)
 		if err != nil {
-			fmt.Printf("failed to run %s: %s\n", binName, err.Error())
+			fmt.Printf("failed to run %s: %s\n", editor.Name, err.Error())
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}
+
+	if len(commands) == 1 && commands[0] == "workspaces" {
+		for _, name := range config.ListWorkspaces() {
+			fmt.Println(name)
+		}
+
+		os.Exit(0)
+	}
+
+	if *workspace != "" {
+		err := code.RunWorkspace(editor, *workspace)
+		if err != nil {
+			fmt.Printf("failed to run %s: %s\n", editor.Name, err.Error())
 			os.Exit(1)
 		}
 
@@ -106,9 +203,9 @@ func main() {
 	}
 
 	if *isLatest {
-		err := code.RunLatest(binName)
+		err := code.RunLatest(editor)
 		if err != nil {
-			fmt.Printf("failed to run %s: %s\n", binName, err.Error())
+			fmt.Printf("failed to run %s: %s\n", editor.Name, err.Error())
 			os.Exit(1)
 		}
 
@@ -116,9 +213,9 @@ func main() {
 	}
 
 	if *openShortcut != "" {
-		err := code.RunShortcut(binName, *shortcutName)
+		err := code.RunShortcut(editor, *shortcutName)
 		if err != nil {
-			fmt.Printf("failed to run %s: %s\n", binName, err.Error())
+			fmt.Printf("failed to run %s: %s\n", editor.Name, err.Error())
 			os.Exit(1)
 		}
 
@@ -128,3 +225,14 @@ func main() {
 	flag.Usage()
 	os.Exit(1)
 }
+
+// isSubcommand reports whether arg is the subcommand name rather than a path,
+// so directories named like a subcommand can still be opened.
+func isSubcommand(arg string, name string) bool {
+	if arg != name {
+		return false
+	}
+
+	_, err := os.Stat(arg)
+	return os.IsNotExist(err)
+}
@@ -20,12 +20,17 @@ func main() {
 		}
 	}
 
-	var host string
-	var port int
 	var v bool
-
-	flag.StringVar(&host, "host", "127.0.0.1", "IPC server host")
-	flag.IntVar(&port, "port", 7532, "IPC server port")
+	opts := &ssh.Options{}
+
+	flag.StringVar(&opts.Host, "host", "", "IPC server host (default: discovered from the running gssh-ipc)")
+	flag.IntVar(&opts.Port, "port", 0, "IPC server port (default: discovered from the running gssh-ipc)")
+	flag.StringVar(&opts.Forward, "forward", ssh.FORWARD_UNIX, "Reverse forward mode: unix | tcp | auto (unix, falling back to tcp)")
+	flag.IntVar(&opts.RemotePort, "remote-port", 0, "Remote loopback port in tcp forward mode, 0 picks a random one")
+	flag.BoolVar(&opts.Control, "control", false, "Run ssh as a ControlMaster to allow gcode forward on the remote")
+	flag.BoolVar(&opts.Reconnect, "reconnect", false, "Reconnect with the same session when the connection drops")
+	flag.BoolVar(&opts.Strict, "strict", false, "Fail instead of falling back to plain ssh when gssh can't set up the session")
+	flag.BoolVar(&opts.Embedded, "embedded", false, "Serve the IPC protocol in gssh itself instead of the gssh-ipc daemon")
 	flag.BoolVar(&v, "v", false, "Show version")
 	flag.Parse()
 
@@ -34,6 +39,19 @@ func main() {
 		os.Exit(0)
 	}
 
+	switch opts.Forward {
+	case ssh.FORWARD_AUTO, ssh.FORWARD_UNIX, ssh.FORWARD_TCP:
+	default:
+		fmt.Printf("Error: unknown forward mode: %s\n", opts.Forward)
+		os.Exit(1)
+	}
+
+	if opts.Control && runtime.GOOS == "windows" {
+		fmt.Println("Warning: -control is not supported on windows, ignored")
+		opts.Control = false
+	}
+
 	config.InitGCodeEnv()
-	ssh.Run(host, port, flag.Args())
+	config.InitLogger("gssh")
+	ssh.Run(opts, flag.Args())
 }
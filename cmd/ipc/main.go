@@ -3,8 +3,11 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log/slog"
+	"net"
 	"os"
 	"runtime"
+	"time"
 
 	"github.com/xingty/rcode-go/gcode/config"
 	"github.com/xingty/rcode-go/gcode/ipc"
@@ -13,14 +16,32 @@ import (
 var version = "0.0.10"
 
 func main() {
+	if len(os.Args) > 1 && ipc.ADMIN_COMMANDS.Has(os.Args[1]) {
+		err := ipc.RunAdminCommand(os.Args[1], os.Args[2:])
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "install-service" {
+		installService(os.Args[2:])
+	}
+
 	var host string
 	var port int
 	var maxIdleTime int
+	var maxRPCIdleTime int
 	var v bool
+	var unsafeBind bool
 
 	flag.StringVar(&host, "host", "127.0.0.1", "IPC server host")
-	flag.IntVar(&port, "port", 7532, "IPC server port")
-	flag.IntVar(&maxIdleTime, "max-idle", 600, "Max idle time in seconds")
+	flag.IntVar(&port, "port", 7532, "IPC server port, 0 picks a free port")
+	flag.IntVar(&maxIdleTime, "max-idle", 600, "Exit after this many seconds without sessions, 0 disables it")
+	flag.IntVar(&maxRPCIdleTime, "max-rpc-idle", 0, "Exit after this many seconds without requests, 0 disables it")
+	flag.BoolVar(&unsafeBind, "unsafe-bind", false, "Allow binding to a non-loopback address")
 	flag.BoolVar(&v, "v", false, "Show version")
 	flag.Parse()
 
@@ -29,7 +50,84 @@ func main() {
 		os.Exit(0)
 	}
 
+	if !ipc.IsLoopbackHost(host) {
+		if !unsafeBind {
+			fmt.Printf("Error: refusing to bind to non-loopback address %s, use -unsafe-bind to override\n", host)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Warning: binding to non-loopback address %s exposes gssh-ipc to the network\n", host)
+	}
+
+	explicitPort := false
+	flag.Visit(func(f *flag.Flag) {
+		explicitPort = explicitPort || f.Name == "port"
+	})
+
 	config.InitGCodeEnv()
-	server := ipc.NewIPCServerSocket(maxIdleTime)
-	server.Start(host, port)
+	config.InitLogger("gssh-ipc")
+	lock, err := ipc.AcquireInstance()
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	defer lock.Unlock()
+
+	listener, err := listen(host, port, explicitPort)
+	if err != nil {
+		slog.Error("failed to start server", "error", err)
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	server := ipc.NewIPCServerSocket(config.IdlePolicy{
+		NoSessions: time.Duration(maxIdleTime) * time.Second,
+		NoRPCs:     time.Duration(maxRPCIdleTime) * time.Second,
+	})
+	server.Reload()
+	server.Serve(listener)
+}
+
+func listen(host string, port int, explicitPort bool) (net.Listener, error) {
+	listeners, err := ipc.ActivationListeners()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(listeners) > 0 {
+		for _, extra := range listeners[1:] {
+			extra.Close()
+		}
+
+		slog.Info("socket activated", "sockets", len(listeners))
+		return listeners[0], nil
+	}
+
+	listener, err := ipc.Listen(host, port)
+	if err != nil && !explicitPort {
+		// the default port is taken by someone else, pick a free one. gssh
+		// finds it in the runtime file.
+		slog.Warn("failed to listen, picking a free port", "port", port, "error", err)
+		listener, err = ipc.Listen(host, 0)
+	}
+
+	return listener, err
+}
+
+func installService(args []string) {
+	var host string
+	var port int
+
+	flags := flag.NewFlagSet("install-service", flag.ExitOnError)
+	flags.StringVar(&host, "host", "127.0.0.1", "IPC server host")
+	flags.IntVar(&port, "port", 7532, "IPC server port")
+	flags.Parse(args)
+
+	err := ipc.InstallService(host, port)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	os.Exit(0)
 }
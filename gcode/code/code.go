@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,9 +20,11 @@ import (
 	"github.com/xingty/rcode-go/pkg/utils/sshconf"
 )
 
-const MAX_IDLE_TIME = 4 * 60 * 60 * 100
+// ipc sockets of the remote editor not accessed for 4 hours are considered
+// stale and skipped
+const MAX_IDLE_TIME = 4 * time.Hour
 
-var IS_RSSH_CLIENT = os.Getenv("RSSH_SID") != "" && os.Getenv("RSSH_SKEY") != ""
+var IS_RSSH_CLIENT = os.Getenv(config.ENV_RSSH_SID) != "" && os.Getenv(config.ENV_RSSH_SKEY) != ""
 
 type FileInfo struct {
 	Path  string
@@ -30,19 +33,21 @@ type FileInfo struct {
 
 func IsSocketOpen(addr string) bool {
 	socks := ipc.NewIPCClientSocket(addr)
-	return socks.Connect("unix") == nil
-}
-
-func GetCliPath(binName string) (string, error) {
-	if !config.SUPPORTED_IDE.Has(binName) {
-		return "", errors.New("unsupported ide")
+	if socks.Connect("unix") != nil {
+		return false
 	}
 
-	binPath := binName
-	if binName == "code" {
-		binPath = "vscode"
+	socks.Close()
+	return true
+}
+
+func GetCliPath(editor *config.Editor) (string, error) {
+	if editor.Server == "" {
+		return "", fmt.Errorf("%s has no remote server", editor.Name)
 	}
 
+	binName := editor.Bin
+	binPath := editor.Server
 	homeDir, _ := os.UserHomeDir()
 	codePath := fmt.Sprintf("%s/.%s-server/cli/servers", homeDir, binPath)
 	servers, err := filepath.Glob(codePath + "/Stable-*")
@@ -60,23 +65,15 @@ func GetCliPath(binName string) (string, error) {
 		return cli, nil
 	}
 
-	err = fmt.Errorf("can't find .%s-server at home dir. please install it fist", binName)
+	err = fmt.Errorf("can't find .%s-server at home dir. please install it fist", binPath)
 	return "", err
 }
 
-func IsRemote(binName string) (bool, error) {
-	if !config.SUPPORTED_IDE.Has(binName) {
-		return false, nil
-	}
-
+func IsRemote() (bool, error) {
 	return IS_RSSH_CLIENT || os.Getenv("SSH_CLIENT") != "", nil
 }
 
-func GetIpcSocket(binName string) (string, error) {
-	if !config.SUPPORTED_IDE.Has(binName) {
-		return "", errors.New("unsupported ide")
-	}
-
+func GetIpcSocket(editor *config.Editor) (string, error) {
 	uid := os.Getuid()
 	path := fmt.Sprintf("/run/user/%d/vscode-ipc-*.sock", uid)
 	if runtime.GOOS == "darwin" {
@@ -91,17 +88,17 @@ func GetIpcSocket(binName string) (string, error) {
 		return "", fmt.Errorf("can't find ipc socket")
 	}
 
-	return NextOpenSocket(SortByAccessTime(paths), binName)
+	return NextOpenSocket(SortByAccessTime(paths), editor.Server)
 }
 
-func NextOpenSocket(list []FileInfo, binName string) (string, error) {
+func NextOpenSocket(list []FileInfo, server string) (string, error) {
 	now := time.Now().Unix()
 	for _, info := range list {
-		if now-info.Atime > MAX_IDLE_TIME {
+		if time.Duration(now-info.Atime)*time.Second > MAX_IDLE_TIME {
 			continue
 		}
 
-		if IsSocketOpen(info.Path) && IsSocketProcessRunning(info.Path, binName) {
+		if IsSocketOpen(info.Path) && IsSocketProcessRunning(info.Path, server) {
 			return info.Path, nil
 		}
 	}
@@ -126,7 +123,7 @@ func SortByAccessTime(paths []string) []FileInfo {
 	return list
 }
 
-func IsSocketProcessRunning(sock string, binName string) bool {
+func IsSocketProcessRunning(sock string, server string) bool {
 	output, err := exec.Command("lsof", "-t", sock).Output()
 	if err != nil {
 		return false
@@ -139,36 +136,35 @@ func IsSocketProcessRunning(sock string, binName string) bool {
 		return false
 	}
 
-	keyword := binName + "-server"
+	keyword := server + "-server"
 	return strings.Contains(string(data), keyword)
 }
 
 func RunLocal(
-	binName string,
+	editor *config.Editor,
 	hostname string,
 	dirName string,
 	shortcutName string) error {
 
-	home, _ := os.UserHomeDir()
-	if strings.HasPrefix(dirName, "~/") {
-
-		cfgFile := filepath.Join(home, "/.ssh/config")
-		config := sshconf.NewSSHConfig(cfgFile)
-		host := config.GetHost(hostname)
-		if host == nil {
-			return errors.New("couldn't expand user home directory")
-		}
+	dirName, err := expandRemoteHome(hostname, dirName)
+	if err != nil {
+		return err
+	}
 
-		dirName = "/home/" + host.GetUser("root") + dirName[1:]
+	target, err := editor.Resolve(config.Target{Host: hostname, Path: dirName})
+	if err != nil {
+		return err
 	}
 
-	remoteURI := fmt.Sprintf("vscode-remote://ssh-remote+%s%s", hostname, dirName)
+	home, _ := os.UserHomeDir()
+	remoteURI := editor.URI(target)
 	file := filepath.Join(home, ".gcode", "gcode")
 	fs, _ := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	defer fs.Close()
 	fs.WriteString(fmt.Sprintf("%s,%s\n", shortcutName, remoteURI))
 
-	err := exec.Command(binName, "--folder-uri", remoteURI).Run()
+	bin, args := editor.Command(target)
+	err = exec.Command(bin, args...).Run()
 	if err != nil {
 		return err
 	}
@@ -176,7 +172,74 @@ func RunLocal(
 	return nil
 }
 
-func RunLatest(binName string) error {
+// expandRemoteHome expands ~/ of a path on hostname, the home directory is
+// guessed from the user in the ssh config.
+func expandRemoteHome(hostname string, path string) (string, error) {
+	if !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+
+	home, _ := os.UserHomeDir()
+	cfgFile := filepath.Join(home, "/.ssh/config")
+	config := sshconf.NewSSHConfig(cfgFile)
+	host := config.GetHost(hostname)
+	if host == nil {
+		return "", errors.New("couldn't expand user home directory")
+	}
+
+	return "/home/" + host.GetUser("root") + path[1:], nil
+}
+
+// RunLocalDiff compares remote, given as host:path, with a local file.
+func RunLocalDiff(editor *config.Editor, remote string, local string, options models.OpenOptions) error {
+	hostname, file, ok := strings.Cut(remote, ":")
+	if !ok || hostname == "" || file == "" {
+		return fmt.Errorf("remote file must be host:path, got %s", remote)
+	}
+
+	file, err := expandRemoteHome(hostname, file)
+	if err != nil {
+		return err
+	}
+
+	local, err = filepath.Abs(local)
+	if err != nil {
+		return err
+	}
+
+	options.Diff = true
+	target, err := editor.Prepare(config.Target{
+		Host:      hostname,
+		Paths:     []string{file},
+		LocalFile: local,
+		Options:   options,
+	})
+	if err != nil {
+		return err
+	}
+
+	bin, args := editor.Command(target)
+	return exec.Command(bin, args...).Run()
+}
+
+// openURI opens a URI recorded by RunLocal.
+func openURI(editor *config.Editor, remoteURI string) error {
+	bin, args := editor.URICommand(remoteURI, false)
+	return exec.Command(bin, args...).Run()
+}
+
+// RunWorkspace opens a workspace saved by remote gcode.
+func RunWorkspace(editor *config.Editor, name string) error {
+	file := config.WorkspaceFile(name)
+	if _, err := os.Stat(file); err != nil {
+		return fmt.Errorf("workspace not found: %s", name)
+	}
+
+	bin, args := editor.URICommand(config.LocalFileURI(file), true)
+	return exec.Command(bin, args...).Run()
+}
+
+func RunLatest(editor *config.Editor) error {
 	recordFile := fmt.Sprintf("%s/.gcode/gcode", config.HOME)
 	content, err := os.ReadFile(recordFile)
 	if err != nil {
@@ -193,14 +256,13 @@ func RunLatest(binName string) error {
 		segs := strings.Split(lines[i], ",")
 		remoteURI := strings.TrimSpace(segs[len(segs)-1])
 
-		err := exec.Command(binName, "--folder-uri", remoteURI).Run()
-		return err
+		return openURI(editor, remoteURI)
 	}
 
 	return nil
 }
 
-func RunShortcut(binName string, shortcutName string) error {
+func RunShortcut(editor *config.Editor, shortcutName string) error {
 	recordFile := fmt.Sprintf("%s/.gcode/gcode", config.HOME)
 	content, err := os.ReadFile(recordFile)
 	if err != nil {
@@ -212,91 +274,134 @@ func RunShortcut(binName string, shortcutName string) error {
 		segs := strings.Split(line, ",")
 		if shortcutName == strings.TrimSpace(segs[0]) {
 			remoteURI := strings.TrimSpace(segs[len(segs)-1])
-			return exec.Command(binName, "--folder-uri", remoteURI).Run()
+			return openURI(editor, remoteURI)
 		}
 	}
 
 	return errors.New("shortcut not found: " + shortcutName)
 }
 
-func sendMessage(binName string, dirName string, sid string, skey string) error {
-	ipcSock := fmt.Sprintf("/tmp/rssh-ipc-%s.sock", sid)
-	sock := ipc.NewIPCClientSocket(ipcSock)
-	err := sock.Connect("unix")
+// sendMessage asks gssh-ipc to open the editor. The call has no deadline, it
+// lasts as long as the editor with --wait.
+func sendMessage(params models.OpenIDEParams) (models.OpenIDEResult, error) {
+	result := models.OpenIDEResult{}
+	network, addr := ipc.SessionAddr(params.Sid)
+	data, err := ipc.Call(network, addr, "open_ide", params)
 	if err != nil {
-		return err
-	}
-
-	defer sock.Close()
-	params := models.OpenIDEParams{
-		Sid:  sid,
-		Skey: skey,
-		Path: dirName,
-		Bin:  binName,
+		return result, err
 	}
 
-	rawParams, _ := json.Marshal(params)
+	// older servers return no result
+	json.Unmarshal(data, &result)
+	return result, nil
+}
 
-	payload := models.MessagePayload{
-		Method: "open_ide",
-		Params: rawParams,
+// RunRemote opens paths in the local editor through gssh, it returns the
+// exit code of the editor with --wait. Files are opened in the window of the
+// directory, or of their project root withRoot. Several directories are
+// opened as a multi-root workspace saved as workspace.
+func RunRemote(editor *config.Editor, paths []string, options models.OpenOptions, withRoot bool, workspace string, devcontainer bool) (code int, err error) {
+	if len(paths) == 0 {
+		return 0, fmt.Errorf(`need dir name here\n`)
 	}
 
-	data, err := json.Marshal(payload)
+	err = options.Validate(len(paths))
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	err = sock.Send(data)
-	if err != nil {
-		return err
-	}
+	for i, path := range paths {
+		suffix := ""
+		if options.Goto {
+			path, suffix = splitLineColumn(path)
+		}
 
-	resData, err := sock.Receive()
-	if err != nil {
-		return err
+		path, _ = filepath.Abs(path)
+		paths[i] = path + suffix
 	}
 
-	res := &models.ResponsePayload[any]{}
-
-	err = json.Unmarshal(resData, res)
-	if err != nil {
-		return err
-	}
+	dirName := paths[0]
+	files := make([]string, 0)
+	folders := make([]string, 0)
+	if !options.Goto && !options.Diff && !options.Merge {
+		dirs, fileArgs, err := classifyPaths(paths)
+		if err != nil {
+			return 0, err
+		}
 
-	if res.Code != 0 {
-		return errors.New(res.Message)
+		files = fileArgs
+		dirName = ""
+		if len(dirs) > 1 {
+			folders = dirs
+		} else if len(dirs) == 1 {
+			dirName = dirs[0]
+		} else if withRoot {
+			dirName = ProjectRoot(files[0])
+		}
 	}
 
-	return nil
-}
+	if workspace != "" && len(folders) == 0 {
+		if dirName == "" || options.Goto || options.Diff || options.Merge {
+			return 0, fmt.Errorf("-ws needs at least one directory")
+		}
 
-func RunRemote(binName string, dirName string, maxIdleTime int) error {
-	if len(dirName) == 0 {
-		return fmt.Errorf(`need dir name here\n`)
+		// a single directory is saved as a workspace as well
+		folders, dirName = []string{dirName}, ""
 	}
 
-	stat, err := os.Stat(dirName)
-	if err != nil {
-		return err
+	if devcontainer && editor.Kind != config.EDITOR_KIND_VSCODE {
+		return 0, fmt.Errorf("%s doesn't support dev containers", editor.Name)
 	}
 
-	if !stat.IsDir() {
-		return fmt.Errorf("%s is not a directory", dirName)
+	var container *models.DevContainer
+	if editor.Kind == config.EDITOR_KIND_VSCODE && dirName != "" && len(files) == 0 && !options.Goto && !options.Diff && !options.Merge {
+		container, err = devContainer(dirName, devcontainer)
+		if err != nil {
+			return 0, err
+		}
+	} else if devcontainer {
+		return 0, fmt.Errorf("-devcontainer opens a single directory")
+	}
+
+	// check what gssh-ipc checks before any file is created
+	_, err = editor.Prepare(config.Target{
+		Path:         dirName,
+		Paths:        paths,
+		Files:        files,
+		Folders:      folders,
+		DevContainer: container,
+		Options:      options,
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	if !config.SUPPORTED_IDE.Has(binName) {
-		return fmt.Errorf(`unsupported ide: %s\n`, binName)
+	created, err := createFiles(files)
+	if err != nil {
+		return 0, err
 	}
+	defer func() {
+		if err != nil {
+			removeEmptyFiles(created)
+		}
+	}()
 
 	if IS_RSSH_CLIENT {
 		// communicate with rssh's IPC Socket
-		sid := os.Getenv("RSSH_SID")
-		skey := os.Getenv("RSSH_SKEY")
-
-		err := sendMessage(binName, dirName, sid, skey)
+		result, err := sendMessage(models.OpenIDEParams{
+			Sid:          os.Getenv(config.ENV_RSSH_SID),
+			Skey:         os.Getenv(config.ENV_RSSH_SKEY),
+			Bin:          editor.Name,
+			Path:         dirName,
+			Paths:        paths,
+			Files:        files,
+			Folders:      folders,
+			Workspace:    workspace,
+			DevContainer: container,
+			OpenOptions:  options,
+		})
 		if err == nil {
-			return nil
+			return result.ExitCode, nil
 		}
 
 		fmt.Printf("failed to send message: %s\ntrying fallback to vscode's IPC socket", err.Error())
@@ -304,20 +409,104 @@ func RunRemote(binName string, dirName string, maxIdleTime int) error {
 		fmt.Println("Warning: seems not running in gssh, trying fallback to vscode's IPC socket")
 	}
 
-	cli, err := GetCliPath(binName)
+	if container != nil {
+		fmt.Println("Warning: dev containers need gssh, opening over ssh")
+	}
+
+	cli, err := GetCliPath(editor)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	ipc_socket, err := GetIpcSocket(binName)
+	ipc_socket, err := GetIpcSocket(editor)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	os.Setenv("VSCODE_IPC_HOOK_CLI", ipc_socket)
-	err = exec.Command(cli, dirName, ipc_socket).Run()
+	args := append(cliArgs(options), paths...)
+	err = exec.Command(cli, append(args, ipc_socket)...).Run()
+	var exitErr *exec.ExitError
+	if options.Wait && errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+
+	return 0, err
+}
+
+// RunRemoteDiff compares a remote file with a file on the machine of the
+// editor. There is no fallback, VS Code's IPC socket can't see local files.
+func RunRemoteDiff(editor *config.Editor, file string, local string, options models.OpenOptions) (int, error) {
+	if !IS_RSSH_CLIENT {
+		return 0, fmt.Errorf("diff with a local file only works in gssh")
+	}
+
+	file, err := filepath.Abs(file)
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	options.Diff = true
+	result, err := sendMessage(models.OpenIDEParams{
+		Sid:         os.Getenv(config.ENV_RSSH_SID),
+		Skey:        os.Getenv(config.ENV_RSSH_SKEY),
+		Bin:         editor.Name,
+		Path:        file,
+		Paths:       []string{file},
+		Local:       local,
+		OpenOptions: options,
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	return nil
+	return result.ExitCode, nil
+}
+
+// splitLineColumn splits file:line[:col] into the file and the :line[:col]
+// suffix.
+func splitLineColumn(path string) (string, string) {
+	file := path
+	for range 2 {
+		index := strings.LastIndex(file, ":")
+		if index == -1 {
+			break
+		}
+
+		if _, err := strconv.Atoi(file[index+1:]); err != nil {
+			break
+		}
+
+		file = file[:index]
+	}
+
+	return file, path[len(file):]
+}
+
+// cliArgs translates options back to the args of the remote VS Code CLI.
+func cliArgs(options models.OpenOptions) []string {
+	flags := []struct {
+		set  bool
+		name string
+	}{
+		{options.Goto, "-g"},
+		{options.Diff, "-d"},
+		{options.Merge, "-m"},
+		{options.NewWindow, "-n"},
+		{options.ReuseWindow, "-r"},
+		{options.Add, "-a"},
+		{options.Wait, "--wait"},
+	}
+
+	args := make([]string, 0)
+	for _, flag := range flags {
+		if flag.set {
+			args = append(args, flag.name)
+		}
+	}
+
+	if options.Profile != "" {
+		args = append(args, "--profile", options.Profile)
+	}
+
+	return args
 }
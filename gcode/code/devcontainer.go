@@ -0,0 +1,167 @@
+package code
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/xingty/rcode-go/gcode/config"
+	"github.com/xingty/rcode-go/pkg/models"
+)
+
+// FindDevContainer returns the devcontainer.json of dir, or "" if there is
+// none.
+func FindDevContainer(dir string) string {
+	for _, name := range config.DEVCONTAINER_FILES {
+		file := filepath.Join(dir, name)
+		if stat, err := os.Stat(file); err == nil && !stat.IsDir() {
+			return file
+		}
+	}
+
+	return ""
+}
+
+// devContainer returns the dev container to open dir in, following
+// GCODE_DEVCONTAINER unless force is set. It returns nil to open dir over ssh.
+func devContainer(dir string, force bool) (*models.DevContainer, error) {
+	configFile := FindDevContainer(dir)
+	if configFile == "" {
+		if force {
+			return nil, fmt.Errorf("no devcontainer.json in %s", dir)
+		}
+
+		return nil, nil
+	}
+
+	if !force {
+		switch policy := os.Getenv(config.ENV_DEVCONTAINER); policy {
+		case "", config.DEVCONTAINER_NEVER:
+			return nil, nil
+		case config.DEVCONTAINER_ASK:
+			fmt.Printf("Reopen %s in its dev container? [y/N] ", dir)
+			answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			answer = strings.ToLower(strings.TrimSpace(answer))
+			if answer != "y" && answer != "yes" {
+				return nil, nil
+			}
+		case config.DEVCONTAINER_AUTO:
+		default:
+			return nil, fmt.Errorf("invalid %s: %s", config.ENV_DEVCONTAINER, policy)
+		}
+	}
+
+	folder, err := workspaceFolder(dir, configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.DevContainer{ConfigFile: configFile, WorkspaceFolder: folder}, nil
+}
+
+// workspaceFolder returns the folder dir is mounted at in the container,
+// /workspaces/<name> unless devcontainer.json sets workspaceFolder.
+func workspaceFolder(dir string, configFile string) (string, error) {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return "", err
+	}
+
+	devcontainer := struct {
+		WorkspaceFolder string `json:"workspaceFolder"`
+	}{}
+	err = json.Unmarshal(stripJSONC(data), &devcontainer)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s: %w", configFile, err)
+	}
+
+	folder := "/workspaces/" + filepath.Base(dir)
+	if devcontainer.WorkspaceFolder != "" {
+		folder = strings.NewReplacer(
+			"${localWorkspaceFolderBasename}", filepath.Base(dir),
+			"${localWorkspaceFolder}", dir,
+		).Replace(devcontainer.WorkspaceFolder)
+	}
+
+	if !path.IsAbs(folder) {
+		return "", fmt.Errorf("unsupported workspaceFolder in %s: %s", configFile, folder)
+	}
+
+	return folder, nil
+}
+
+// stripJSONC turns JSON with comments, as used by devcontainer.json, into
+// JSON. Comments and trailing commas outside of strings are removed.
+func stripJSONC(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		switch {
+		case data[i] == '"':
+			end := stringEnd(data, i)
+			out = append(out, data[i:end]...)
+			i = end - 1
+
+		case bytes.HasPrefix(data[i:], []byte("//")):
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			i--
+
+		case bytes.HasPrefix(data[i:], []byte("/*")):
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end == -1 {
+				return removeTrailingCommas(out)
+			}
+			i += end + 3
+
+		default:
+			out = append(out, data[i])
+		}
+	}
+
+	return removeTrailingCommas(out)
+}
+
+// removeTrailingCommas removes commas followed by } or ] from JSON without
+// comments.
+func removeTrailingCommas(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		switch data[i] {
+		case '"':
+			end := stringEnd(data, i)
+			out = append(out, data[i:end]...)
+			i = end - 1
+
+		case ',':
+			rest := bytes.TrimLeft(data[i+1:], " \t\r\n")
+			if len(rest) == 0 || (rest[0] != '}' && rest[0] != ']') {
+				out = append(out, ',')
+			}
+
+		default:
+			out = append(out, data[i])
+		}
+	}
+
+	return out
+}
+
+// stringEnd returns the index after the JSON string that starts at start.
+func stringEnd(data []byte, start int) int {
+	for i := start + 1; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+
+	return len(data)
+}
@@ -0,0 +1,115 @@
+package code
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWorkspaceFolder(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "default",
+			config: `{"image": "golang"}`,
+			want:   "/workspaces/app",
+		},
+		{
+			name:   "variables",
+			config: `{"workspaceFolder": "/src/${localWorkspaceFolderBasename}"}`,
+			want:   "/src/app",
+		},
+		{
+			name: "commented out",
+			config: `{
+				// "workspaceFolder": "/old",
+				"image": "golang"
+			}`,
+			want: "/workspaces/app",
+		},
+		{
+			name: "block comment",
+			config: `{
+				/* "workspaceFolder": "/old", */
+				"workspaceFolder": "/new"
+			}`,
+			want: "/new",
+		},
+		{
+			name: "comment markers in strings and trailing commas",
+			config: `{
+				"image": "registry.example.com//go /* latest */",
+				"mounts": ["a", "b",],
+				"workspaceFolder": "/src", // the checkout
+			}`,
+			want: "/src",
+		},
+		{
+			name: "nested workspaceFolder is ignored",
+			config: `{
+				"customizations": {"other": {"workspaceFolder": "/nested"}},
+			}`,
+			want: "/workspaces/app",
+		},
+		{
+			name:    "relative",
+			config:  `{"workspaceFolder": "src"}`,
+			wantErr: "unsupported workspaceFolder",
+		},
+		{
+			name:    "invalid",
+			config:  `{"workspaceFolder": }`,
+			wantErr: "invalid",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "app")
+			configFile := filepath.Join(dir, ".devcontainer", "devcontainer.json")
+			os.MkdirAll(filepath.Dir(configFile), 0755)
+			os.WriteFile(configFile, []byte(test.config), 0644)
+
+			got, err := workspaceFolder(dir, configFile)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != test.want {
+				t.Errorf("workspaceFolder = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFindDevContainer(t *testing.T) {
+	dir := t.TempDir()
+	if file := FindDevContainer(dir); file != "" {
+		t.Errorf("FindDevContainer = %s in an empty directory", file)
+	}
+
+	root := filepath.Join(dir, ".devcontainer.json")
+	os.WriteFile(root, []byte("{}"), 0644)
+	if file := FindDevContainer(dir); file != root {
+		t.Errorf("FindDevContainer = %s, want %s", file, root)
+	}
+
+	nested := filepath.Join(dir, ".devcontainer", "devcontainer.json")
+	os.MkdirAll(filepath.Dir(nested), 0755)
+	os.WriteFile(nested, []byte("{}"), 0644)
+	if file := FindDevContainer(dir); file != nested {
+		t.Errorf("FindDevContainer = %s, want %s", file, nested)
+	}
+}
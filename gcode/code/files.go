@@ -0,0 +1,87 @@
+package code
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// files and directories that mark the root of a project
+var PROJECT_MARKERS = []string{".git", ".hg", ".svn", "*.code-workspace"}
+
+// ProjectRoot returns the nearest directory above path that contains a
+// project marker, or "" if there is none.
+func ProjectRoot(path string) string {
+	dir := filepath.Dir(path)
+	for {
+		for _, marker := range PROJECT_MARKERS {
+			matches, _ := filepath.Glob(filepath.Join(dir, marker))
+			if len(matches) > 0 {
+				return dir
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// classifyPaths splits paths into directories and files. A path that
+// doesn't exist is a new file, its directory must exist like with `code`.
+func classifyPaths(paths []string) ([]string, []string, error) {
+	dirs := make([]string, 0)
+	files := make([]string, 0)
+	for _, path := range paths {
+		stat, err := os.Stat(path)
+		if err == nil && stat.IsDir() {
+			dirs = append(dirs, path)
+			continue
+		}
+
+		if os.IsNotExist(err) {
+			if stat, err := os.Stat(filepath.Dir(path)); err != nil || !stat.IsDir() {
+				return nil, nil, fmt.Errorf("no such directory: %s", filepath.Dir(path))
+			}
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		files = append(files, path)
+	}
+
+	return dirs, files, nil
+}
+
+// createFiles creates the files that don't exist yet, so the editor can open
+// them remotely. It returns the created files.
+func createFiles(files []string) ([]string, error) {
+	created := make([]string, 0)
+	for _, path := range files {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+
+		if err != nil {
+			removeEmptyFiles(created)
+			return nil, err
+		}
+
+		file.Close()
+		created = append(created, path)
+	}
+
+	return created, nil
+}
+
+// removeEmptyFiles removes files created by createFiles that are still empty.
+func removeEmptyFiles(files []string) {
+	for _, path := range files {
+		if stat, err := os.Stat(path); err == nil && stat.Size() == 0 {
+			os.Remove(path)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package code
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/xingty/rcode-go/gcode/config"
+	"github.com/xingty/rcode-go/gcode/ipc"
+	"github.com/xingty/rcode-go/pkg/models"
+)
+
+// RunForward asks gssh-ipc to add, remove or list local port forwards of
+// the current gssh session, then prints the active forwards.
+func RunForward(action string, port string) error {
+	if !IS_RSSH_CLIENT {
+		return errors.New("not running in gssh")
+	}
+
+	if action != "list" && port == "" {
+		return fmt.Errorf("usage: forward %s <port>|<local port>:<remote port>", action)
+	}
+
+	sid := os.Getenv(config.ENV_RSSH_SID)
+	params := models.ForwardParams{
+		Sid:    sid,
+		Skey:   os.Getenv(config.ENV_RSSH_SKEY),
+		Action: action,
+		Port:   port,
+	}
+
+	network, addr := ipc.SessionAddr(sid)
+	data, err := ipc.Call(network, addr, "forward", params)
+	if err != nil {
+		return err
+	}
+
+	forwards := make([]string, 0)
+	json.Unmarshal(data, &forwards)
+	if len(forwards) == 0 {
+		fmt.Println("no forwards")
+	}
+
+	for _, spec := range forwards {
+		fmt.Println(spec)
+	}
+
+	return nil
+}
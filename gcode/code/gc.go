@@ -0,0 +1,86 @@
+package code
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/xingty/rcode-go/gcode/config"
+	"github.com/xingty/rcode-go/gcode/ipc"
+)
+
+const RSSH_SOCKET_PATTERN = "/tmp/rssh-ipc-*.sock"
+
+// max sockets probed by the sweep each time gcode starts, the oldest first
+const SWEEP_LIMIT = 16
+
+// CleanStaleSockets removes rssh-ipc sockets left behind by closed gssh
+// sessions. Only sockets owned by the current user that no longer accept
+// connections are removed. At most limit sockets are probed, all of them if
+// limit is 0. It returns the number of removed sockets.
+func CleanStaleSockets(limit int) (int, error) {
+	paths, err := filepath.Glob(RSSH_SOCKET_PATTERN)
+	if err != nil {
+		return 0, err
+	}
+
+	current := ""
+	if sid := os.Getenv(config.ENV_RSSH_SID); sid != "" {
+		current = ipc.SessionSocket(sid)
+	}
+
+	type socket struct {
+		path    string
+		modTime time.Time
+	}
+
+	candidates := make([]socket, 0)
+	for _, path := range paths {
+		if path == current {
+			continue
+		}
+
+		info, err := os.Lstat(path)
+		if err != nil || info.Mode()&os.ModeSocket == 0 || !isOwnedByCurrentUser(info) {
+			continue
+		}
+
+		candidates = append(candidates, socket{path, info.ModTime()})
+	}
+
+	slices.SortFunc(candidates, func(a, b socket) int {
+		return a.modTime.Compare(b.modTime)
+	})
+	if limit > 0 && len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+
+	// a probe only connects, so it doesn't count as a request of gssh-ipc.
+	// They run concurrently as a socket of a hung session may take up to
+	// the connect timeout.
+	stale := make([]bool, len(candidates))
+	var wg sync.WaitGroup
+	for i, candidate := range candidates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stale[i] = !IsSocketOpen(candidate.path)
+		}()
+	}
+	wg.Wait()
+
+	removed := 0
+	for i, candidate := range candidates {
+		if !stale[i] {
+			continue
+		}
+
+		if err := os.Remove(candidate.path); err == nil {
+			removed++
+		}
+	}
+
+	return removed, nil
+}
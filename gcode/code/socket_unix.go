@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package code
+
+import (
+	"os"
+	"syscall"
+)
+
+func isOwnedByCurrentUser(info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+
+	return int(stat.Uid) == os.Getuid()
+}
@@ -1,15 +1,17 @@
 package config
 
 import (
-	"log"
 	"os"
 	"path/filepath"
 
 	"github.com/google/uuid"
-	"github.com/xingty/rcode-go/pkg/utils"
 )
 
 const ENV_DEBUG = "GCODE_DEBUG"
+const ENV_RSSH_SID = "RSSH_SID"
+const ENV_RSSH_SKEY = "RSSH_SKEY"
+const ENV_RSSH_ADDR = "RSSH_ADDR"
+const ENV_DEVCONTAINER = "GCODE_DEVCONTAINER"
 
 var HOME, _ = os.UserHomeDir()
 
@@ -17,8 +19,11 @@ var GCODE_HOME = filepath.Join(HOME, ".gcode")
 var GCCODE_CONFIG = filepath.Join(GCODE_HOME, "gcode")
 var GCODE_KEY_FILE = filepath.Join(GCODE_HOME, "keyfile")
 var RSSH_KEY_FILE = filepath.Join(HOME, ".rssh", "keyfile")
-
-var SUPPORTED_IDE = utils.NewSet("code", "cursor", "windsurf", "trae")
+var GCODE_SSH_CONFIG = filepath.Join(GCODE_HOME, "ssh_config")
+var GCODE_RUN_DIR = filepath.Join(GCODE_HOME, "run")
+var GSSH_IPC_LOCK_FILE = filepath.Join(GCODE_RUN_DIR, "gssh-ipc.lock")
+var GSSH_IPC_RUNTIME_FILE = filepath.Join(GCODE_RUN_DIR, "gssh-ipc.json")
+var GSSH_IPC_START_LOCK = filepath.Join(GCODE_RUN_DIR, "start.lock")
 
 func InitGCodeEnv() {
 	if _, err := os.Stat(GCODE_HOME); os.IsNotExist(err) {
@@ -26,6 +31,10 @@ func InitGCodeEnv() {
 		os.Mkdir(GCODE_HOME, 0755)
 	}
 
+	if _, err := os.Stat(GCODE_RUN_DIR); os.IsNotExist(err) {
+		os.Mkdir(GCODE_RUN_DIR, 0700)
+	}
+
 	if _, err := os.Stat(GCCODE_CONFIG); os.IsNotExist(err) {
 		file, err := os.Create(GCCODE_CONFIG)
 		if err != nil {
@@ -43,19 +52,4 @@ func InitGCodeEnv() {
 		file.Write([]byte(uuid.New().String()))
 		file.Close()
 	}
-
-	initLogger()
-}
-
-func initLogger() {
-	logDir := filepath.Join(GCODE_HOME, "logs")
-	if _, err := os.Stat(logDir); os.IsNotExist(err) {
-		os.MkdirAll(logDir, 0755)
-	}
-	logFilePath := filepath.Join(logDir, "ipc.log")
-	logFile, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		panic(err)
-	}
-	log.SetOutput(logFile)
 }
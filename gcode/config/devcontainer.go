@@ -0,0 +1,46 @@
+package config
+
+import (
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/xingty/rcode-go/pkg/models"
+)
+
+// policies of GCODE_DEVCONTAINER, whether remote gcode reopens a directory
+// with a devcontainer.json in its dev container
+const DEVCONTAINER_NEVER = "never"
+const DEVCONTAINER_AUTO = "auto"
+const DEVCONTAINER_ASK = "ask"
+
+// devcontainer.json locations relative to the directory, in order
+var DEVCONTAINER_FILES = []string{".devcontainer/devcontainer.json", ".devcontainer.json"}
+
+type devContainerFile struct {
+	Mid       int    `json:"$mid"`
+	Path      string `json:"path"`
+	Scheme    string `json:"scheme"`
+	Authority string `json:"authority"`
+}
+
+type devContainerConfig struct {
+	HostPath   string           `json:"hostPath"`
+	ConfigFile devContainerFile `json:"configFile"`
+}
+
+// DevContainerURI returns the URI of the Dev Containers extension that
+// opens hostPath in the container, nested in the remote of scheme and
+// authority, e.g. vscode-remote and ssh-remote+devbox.
+func DevContainerURI(scheme string, authority string, hostPath string, container models.DevContainer) string {
+	config, _ := json.Marshal(devContainerConfig{
+		HostPath: hostPath,
+		ConfigFile: devContainerFile{
+			Mid:       1,
+			Path:      container.ConfigFile,
+			Scheme:    scheme,
+			Authority: authority,
+		},
+	})
+
+	return scheme + "://dev-container+" + hex.EncodeToString(config) + "@" + authority + container.WorkspaceFolder
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"encoding/hex"
+	"regexp"
+	"testing"
+
+	"github.com/xingty/rcode-go/pkg/models"
+)
+
+var devContainerAuthority = regexp.MustCompile(`^([a-z-]+)://dev-container\+([0-9a-f]+)@`)
+
+func TestDevContainerURI(t *testing.T) {
+	code := BuiltinEditors()["code"]
+	lan := &Editor{Kind: EDITOR_KIND_VSCODE, FolderURI: "vscode-remote://ssh-remote+{host}.lan{path}"}
+
+	tests := []struct {
+		name      string
+		editor    *Editor
+		host      string
+		hostPath  string
+		container models.DevContainer
+		// want is the URI with <config> in place of the hex encoded config
+		want   string
+		config string
+	}{
+		{
+			name:      "default folder",
+			editor:    code,
+			host:      "devbox",
+			hostPath:  "/home/me/x",
+			container: models.DevContainer{ConfigFile: "/home/me/x/.devcontainer/devcontainer.json", WorkspaceFolder: "/workspaces/x"},
+			want:      "vscode-remote://dev-container+<config>@ssh-remote+devbox/workspaces/x",
+			config:    `{"hostPath":"/home/me/x","configFile":{"$mid":1,"path":"/home/me/x/.devcontainer/devcontainer.json","scheme":"vscode-remote","authority":"ssh-remote+devbox"}}`,
+		},
+		{
+			name:      "nested session",
+			editor:    code,
+			host:      "gcode-bastion-inner",
+			hostPath:  "/srv/app",
+			container: models.DevContainer{ConfigFile: "/srv/app/.devcontainer.json", WorkspaceFolder: "/src/app"},
+			want:      "vscode-remote://dev-container+<config>@ssh-remote+gcode-bastion-inner/src/app",
+			config:    `{"hostPath":"/srv/app","configFile":{"$mid":1,"path":"/srv/app/.devcontainer.json","scheme":"vscode-remote","authority":"ssh-remote+gcode-bastion-inner"}}`,
+		},
+		{
+			name:      "authority of the template",
+			editor:    lan,
+			host:      "devbox",
+			hostPath:  "/home/me/x",
+			container: models.DevContainer{ConfigFile: "/home/me/x/.devcontainer.json", WorkspaceFolder: "/workspaces/x"},
+			want:      "vscode-remote://dev-container+<config>@ssh-remote+devbox.lan/workspaces/x",
+			config:    `{"hostPath":"/home/me/x","configFile":{"$mid":1,"path":"/home/me/x/.devcontainer.json","scheme":"vscode-remote","authority":"ssh-remote+devbox.lan"}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			container := test.container
+			uri := test.editor.URI(Target{Host: test.host, Path: test.hostPath, DevContainer: &container})
+
+			match := devContainerAuthority.FindStringSubmatch(uri)
+			if match == nil {
+				t.Fatalf("%s is not a dev container URI", uri)
+			}
+
+			config, err := hex.DecodeString(match[2])
+			if err != nil {
+				t.Fatalf("invalid hex in %s: %s", uri, err)
+			}
+
+			if string(config) != test.config {
+				t.Errorf("config = %s, want %s", config, test.config)
+			}
+
+			got := regexp.MustCompile(`\+[0-9a-f]+@`).ReplaceAllString(uri, "+<config>@")
+			if got != test.want {
+				t.Errorf("URI = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,596 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/xingty/rcode-go/pkg/models"
+	"github.com/xingty/rcode-go/pkg/utils/sshconf"
+)
+
+var GCODE_EDITORS_FILE = filepath.Join(GCODE_HOME, "editors.json")
+
+const (
+	EDITOR_KIND_VSCODE   = "vscode"
+	EDITOR_KIND_GATEWAY  = "jetbrains-gateway"
+	EDITOR_KIND_ZED      = "zed"
+	EDITOR_KIND_TERMINAL = "terminal"
+	EDITOR_KIND_TRAMP    = "tramp"
+)
+
+// default URI templates of each kind
+var EDITOR_URIS = map[string]string{
+	EDITOR_KIND_VSCODE:  "vscode-remote://ssh-remote+{host}{path}",
+	EDITOR_KIND_GATEWAY: "jetbrains-gateway://connect#type=ssh&deploy=true&productCode={product}&host={hostname}&port={port}&user={user}&projectPath={path}",
+	EDITOR_KIND_ZED:     "ssh://{user}@{hostname}:{port}{path}",
+	// host:path, the terminal runs ssh to host
+	EDITOR_KIND_TERMINAL: "{host}:{path}",
+	EDITOR_KIND_TRAMP:    "/ssh:{host}:{path}",
+}
+
+// Editor is an entry of the editor registry. The registry is built in and
+// extended by ~/.gcode/editors.json, an entry there with the name of a built
+// in editor replaces it.
+type Editor struct {
+	Name string `json:"-"`
+	// how the editor is launched, vscode if empty
+	Kind string `json:"kind"`
+	// local executable, URIs of a gateway editor are opened by the system
+	// if it is empty
+	Bin string `json:"bin"`
+	// names gcode may be invoked as, e.g. through a wrapper or a symlink
+	Aliases []string `json:"aliases"`
+	// URI templates, {host}, {hostname}, {user}, {port}, {path} and
+	// {product} are replaced
+	FolderURI string `json:"folder_uri"`
+	FileURI   string `json:"file_uri"`
+	// extra args passed before the URI
+	Args []string `json:"args"`
+	// the remote server lives in ~/.<server>-server, it is used when gcode
+	// runs outside of gssh
+	Server string `json:"server"`
+	// JetBrains product code of the IDE backend, e.g. IU or GO. Products
+	// maps host patterns to product codes and takes precedence.
+	Product  string            `json:"product"`
+	Products map[string]string `json:"products"`
+	// the command a terminal editor runs on the remote, e.g. vim or hx
+	RemoteEditor string `json:"editor"`
+	// the local terminal a terminal editor runs in, e.g. ["alacritty", "-e"].
+	// The ssh command is appended, the system terminal is used if empty.
+	Terminal []string `json:"terminal"`
+}
+
+// Target is what an editor opens. HostName, User and Port are resolved from
+// Host by ssh for the editors that need them.
+type Target struct {
+	Host     string
+	HostName string
+	User     string
+	Port     int
+	Path     string
+	File     bool
+	// options of the ssh connection of the session, terminal editors
+	// connect with them
+	SSHOptions []string
+	// Paths of -d and -m, Path is the first one
+	Paths []string
+	// Files are opened along with Path, which may be empty
+	Files []string
+	// Folders of a multi-root workspace, written to WorkspaceFile
+	Folders       []string
+	WorkspaceFile string
+	// LocalFile is a file on this machine, the second file of -d
+	LocalFile string
+	// DevContainer reopens Path in its dev container, vscode only
+	DevContainer *models.DevContainer
+	Options      models.OpenOptions
+}
+
+type Editors map[string]*Editor
+
+var BUILTIN_EDITORS = map[string]Editor{
+	"code":     {Bin: "code", Aliases: []string{"gcode"}, Server: "vscode"},
+	"cursor":   {Bin: "cursor", Aliases: []string{"gcursor"}, Server: "cursor"},
+	"windsurf": {Bin: "windsurf", Aliases: []string{"gwindsurf"}, Server: "windsurf"},
+	"trae":     {Bin: "trae", Aliases: []string{"gtrae"}, Server: "trae"},
+	"idea":     {Kind: EDITOR_KIND_GATEWAY, Aliases: []string{"gidea"}, Product: "IU"},
+	"goland":   {Kind: EDITOR_KIND_GATEWAY, Aliases: []string{"ggoland"}, Product: "GO"},
+	"zed":      {Kind: EDITOR_KIND_ZED, Aliases: []string{"gzed"}},
+}
+
+func BuiltinEditors() Editors {
+	editors := make(Editors)
+	for name, editor := range BUILTIN_EDITORS {
+		editor.Aliases = slices.Clone(editor.Aliases)
+		editors[name] = &editor
+	}
+
+	editors.normalize()
+	return editors
+}
+
+// LoadEditors returns the built in editors extended by file. A missing file
+// yields the built in editors.
+func LoadEditors(file string) (Editors, error) {
+	editors := BuiltinEditors()
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return editors, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	custom := make(Editors)
+	err = json.Unmarshal(data, &custom)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", file, err)
+	}
+
+	for name, editor := range custom {
+		editors[name] = editor
+	}
+
+	editors.normalize()
+	err = editors.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", file, err)
+	}
+
+	return editors, nil
+}
+
+func (e Editors) normalize() {
+	for name, editor := range e {
+		editor.Name = name
+		if editor.Kind == "" {
+			editor.Kind = EDITOR_KIND_VSCODE
+		}
+
+		if editor.Bin == "" && editor.Kind == EDITOR_KIND_TRAMP {
+			editor.Bin = "emacsclient"
+		}
+
+		if editor.Bin == "" && editor.Kind != EDITOR_KIND_GATEWAY && editor.Kind != EDITOR_KIND_TERMINAL {
+			editor.Bin = name
+		}
+
+		if editor.FolderURI == "" {
+			editor.FolderURI = EDITOR_URIS[editor.Kind]
+		}
+
+		if editor.FileURI == "" {
+			editor.FileURI = editor.FolderURI
+		}
+	}
+}
+
+func (e Editors) Validate() error {
+	aliases := make(map[string]string)
+	for _, name := range e.Names() {
+		editor := e[name]
+		if _, ok := EDITOR_URIS[editor.Kind]; !ok {
+			return fmt.Errorf("editor %s: unknown kind: %s", name, editor.Kind)
+		}
+
+		if editor.Kind == EDITOR_KIND_GATEWAY && editor.Product == "" {
+			return fmt.Errorf("editor %s: product is required", name)
+		}
+
+		if editor.Kind == EDITOR_KIND_TERMINAL && editor.RemoteEditor == "" {
+			return fmt.Errorf("editor %s: editor is required", name)
+		}
+
+		for pattern := range editor.Products {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("editor %s: invalid products pattern %q: %w", name, pattern, err)
+			}
+		}
+
+		for _, alias := range editor.Aliases {
+			if other, ok := aliases[alias]; ok {
+				return fmt.Errorf("alias %s is used by both %s and %s", alias, other, name)
+			}
+			aliases[alias] = name
+		}
+	}
+
+	return nil
+}
+
+// Names returns the names of the editors, sorted.
+func (e Editors) Names() []string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+	return names
+}
+
+// Aliases returns the aliases of all editors, sorted.
+func (e Editors) Aliases() []string {
+	aliases := make([]string, 0)
+	for _, editor := range e {
+		aliases = append(aliases, editor.Aliases...)
+	}
+
+	slices.Sort(aliases)
+	return aliases
+}
+
+// ByAlias returns the editor gcode is invoked as. The name of an executable
+// is accepted as well, so `gcodium` may be a symlink to gcode.
+func (e Editors) ByAlias(alias string) (*Editor, bool) {
+	alias = strings.TrimSuffix(filepath.Base(alias), ".exe")
+	for _, editor := range e {
+		if slices.Contains(editor.Aliases, alias) {
+			return editor, true
+		}
+	}
+
+	return nil, false
+}
+
+// ProductFor returns the JetBrains product to open host with.
+func (e *Editor) ProductFor(host string) string {
+	if product, ok := e.Products[host]; ok {
+		return product
+	}
+
+	patterns := make([]string, 0, len(e.Products))
+	for pattern := range e.Products {
+		patterns = append(patterns, pattern)
+	}
+
+	slices.Sort(patterns)
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, host); matched {
+			return e.Products[pattern]
+		}
+	}
+
+	return e.Product
+}
+
+// Resolve fills in the hostname, user and port of target if the editor
+// connects by itself rather than through the ssh config. Such editors can't
+// reach hosts behind a jump host, e.g. the aliases of nested gssh sessions.
+func (e *Editor) Resolve(target Target) (Target, error) {
+	if e.Kind != EDITOR_KIND_GATEWAY && e.Kind != EDITOR_KIND_ZED {
+		return target, nil
+	}
+
+	dest, err := sshconf.Resolve(target.Host)
+	if err != nil {
+		return target, err
+	}
+
+	if dest.Proxy != "" {
+		return target, fmt.Errorf("%s can't connect to %s through a jump host (%s)", e.Name, target.Host, dest.Proxy)
+	}
+
+	target.HostName = dest.HostName
+	target.User = dest.User
+	target.Port = dest.Port
+	return target, nil
+}
+
+// URI returns the URI of target from the templates of the editor.
+func (e *Editor) URI(target Target) string {
+	if e.Kind == EDITOR_KIND_VSCODE && target.DevContainer != nil {
+		scheme, authority := e.remoteRoot(target.Host)
+		return DevContainerURI(scheme, authority, target.Path, *target.DevContainer)
+	}
+
+	template := e.FolderURI
+	if target.File {
+		template = e.FileURI
+	}
+
+	escape := func(value string) string { return value }
+	switch e.Kind {
+	case EDITOR_KIND_GATEWAY:
+		escape = func(value string) string {
+			return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+		}
+	case EDITOR_KIND_ZED:
+		escape = func(value string) string {
+			return (&url.URL{Path: value}).EscapedPath()
+		}
+	}
+
+	hostname := escape(target.HostName)
+	if e.Kind == EDITOR_KIND_ZED && strings.Contains(hostname, ":") {
+		hostname = "[" + hostname + "]"
+	}
+
+	return strings.NewReplacer(
+		"{host}", escape(target.Host),
+		"{hostname}", hostname,
+		"{user}", escape(target.User),
+		"{port}", strconv.Itoa(target.Port),
+		"{path}", escape(target.Path),
+		"{product}", escape(e.ProductFor(target.Host)),
+	).Replace(template)
+}
+
+// remoteRoot returns the scheme and the authority of the URIs of host, e.g.
+// vscode-remote and ssh-remote+devbox for vscode-remote://ssh-remote+devbox/path.
+func (e *Editor) remoteRoot(host string) (string, string) {
+	scheme, rest, ok := strings.Cut(e.URI(Target{Host: host, Path: "/"}), "://")
+	if !ok {
+		return "", ""
+	}
+
+	authority, _, _ := strings.Cut(rest, "/")
+	return scheme, authority
+}
+
+// RemoteAuthority returns the authority of the URIs of host, e.g.
+// ssh-remote+devbox.
+func (e *Editor) RemoteAuthority(host string) string {
+	_, authority := e.remoteRoot(host)
+	return authority
+}
+
+// Prepare returns an error if the editor can't open target. Editors other
+// than vscode open a single path, a single file is opened as Path.
+func (e *Editor) Prepare(target Target) (Target, error) {
+	if target.DevContainer != nil {
+		if e.Kind != EDITOR_KIND_VSCODE {
+			return target, fmt.Errorf("%s doesn't support dev containers", e.Name)
+		}
+
+		if target.Path == "" || target.File || len(target.Files) > 0 || len(target.Folders) > 0 ||
+			target.Options.Goto || target.Options.Diff || target.Options.Merge {
+			return target, fmt.Errorf("a dev container opens a single directory")
+		}
+
+		if !strings.HasPrefix(target.DevContainer.WorkspaceFolder, "/") {
+			return target, fmt.Errorf("invalid workspace folder: %s", target.DevContainer.WorkspaceFolder)
+		}
+	}
+
+	if e.Kind == EDITOR_KIND_VSCODE {
+		if target.LocalFile == "" {
+			return target, target.Options.Validate(len(target.Paths))
+		}
+
+		if !target.Options.Diff {
+			return target, fmt.Errorf("a local file can only be compared with -d")
+		}
+
+		return target, target.Options.Validate(len(target.Paths) + 1)
+	}
+
+	if !target.Options.IsZero() {
+		return target, fmt.Errorf("%s doesn't support VS Code options", e.Name)
+	}
+
+	if len(target.Folders) > 0 {
+		return target, fmt.Errorf("%s doesn't support multi-root workspaces", e.Name)
+	}
+
+	if len(target.Files) > 0 {
+		if target.Path != "" || len(target.Files) > 1 {
+			return target, fmt.Errorf("%s opens one path at a time", e.Name)
+		}
+
+		target.Path, target.File, target.Files = target.Files[0], true, nil
+	}
+
+	return target, nil
+}
+
+// Detached reports whether the editor keeps running in the foreground, so
+// it must not be waited for.
+func (e *Editor) Detached() bool {
+	return e.Kind == EDITOR_KIND_TERMINAL
+}
+
+// Command returns the executable and the args to open target.
+func (e *Editor) Command(target Target) (string, []string) {
+	switch e.Kind {
+	case EDITOR_KIND_TERMINAL:
+		return e.terminalCommand(target.Host, target.Path, target.SSHOptions)
+	case EDITOR_KIND_VSCODE:
+		return e.vscodeCommand(target)
+	case EDITOR_KIND_TRAMP:
+		if len(target.SSHOptions) > 0 {
+			return e.trampCommand(target)
+		}
+	}
+
+	return e.URICommand(e.URI(target), target.File)
+}
+
+// trampCommand opens target in emacs through a TRAMP method that passes the
+// ssh options of the session, which TRAMP file names can't carry. The method
+// is a copy of the one of the URI, named gcode-<method>.
+func (e *Editor) trampCommand(target Target) (string, []string) {
+	uri := e.URI(target)
+	method, file, ok := strings.Cut(strings.TrimPrefix(uri, "/"), ":")
+	if !strings.HasPrefix(uri, "/") || !ok {
+		return e.URICommand(uri, target.File)
+	}
+
+	options := make([]string, len(target.SSHOptions))
+	for i, option := range target.SSHOptions {
+		options[i] = ElispString(option)
+	}
+
+	name := ElispString("gcode-" + method)
+	script := fmt.Sprintf(`(progn
+  (require 'tramp-sh)
+  (let ((method (copy-tree (assoc %s tramp-methods))))
+    (setcar method %s)
+    (push '(%s) (cadr (assq 'tramp-login-args (cdr method))))
+    (setq tramp-methods (cons method (assoc-delete-all %s tramp-methods)))
+    (find-file %s)))`,
+		ElispString(method), name, strings.Join(options, " "), name,
+		ElispString("/gcode-"+method+":"+file),
+	)
+
+	// don't wait for the buffer to be closed
+	return e.Bin, append(slices.Clone(e.Args), "-n", "--eval", script)
+}
+
+// ElispString quotes value as an Emacs Lisp string.
+func ElispString(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// vscodeCommand translates the options of target to the VS Code CLI, every
+// path becomes a remote URI.
+func (e *Editor) vscodeCommand(target Target) (string, []string) {
+	options := target.Options
+	args := slices.Clone(e.Args)
+	if options.NewWindow {
+		args = append(args, "--new-window")
+	}
+
+	if options.ReuseWindow {
+		args = append(args, "--reuse-window")
+	}
+
+	if options.Profile != "" {
+		args = append(args, "--profile", options.Profile)
+	}
+
+	if options.Wait {
+		args = append(args, "--wait")
+	}
+
+	fileURI := func(path string) string {
+		return e.URI(Target{Host: target.Host, Path: path, File: true})
+	}
+
+	switch {
+	case options.Diff || options.Merge:
+		args = append(args, lo.Ternary(options.Diff, "--diff", "--merge"))
+		for _, path := range target.Paths {
+			args = append(args, "--file-uri", fileURI(path))
+		}
+
+		if target.LocalFile != "" {
+			args = append(args, "--file-uri", LocalFileURI(target.LocalFile))
+		}
+
+	case options.Goto:
+		args = append(args, "--goto", "--file-uri", fileURI(target.Path))
+
+	default:
+		if options.Add {
+			args = append(args, "--add")
+		}
+
+		if target.File {
+			args = append(args, "--file-uri", e.URI(target))
+		} else if target.Path != "" {
+			args = append(args, "--folder-uri", e.URI(target))
+		} else if target.WorkspaceFile != "" {
+			args = append(args, "--file-uri", LocalFileURI(target.WorkspaceFile))
+		}
+
+		for _, file := range target.Files {
+			args = append(args, "--file-uri", fileURI(file))
+		}
+	}
+
+	return e.Bin, args
+}
+
+// URICommand returns the executable and the args to open uri, a URI built
+// by the editor.
+func (e *Editor) URICommand(uri string, file bool) (string, []string) {
+	args := slices.Clone(e.Args)
+	switch e.Kind {
+	case EDITOR_KIND_GATEWAY:
+		if e.Bin == "" {
+			return OpenURLCommand(uri)
+		}
+
+		return e.Bin, append(args, uri)
+
+	case EDITOR_KIND_ZED:
+		return e.Bin, append(args, uri)
+
+	case EDITOR_KIND_TERMINAL:
+		host, path, _ := strings.Cut(uri, ":")
+		return e.terminalCommand(host, path, nil)
+
+	case EDITOR_KIND_TRAMP:
+		// don't wait for the buffer to be closed
+		return e.Bin, append(args, "-n", uri)
+
+	default:
+		if file {
+			return e.Bin, append(args, "--file-uri", uri)
+		}
+
+		return e.Bin, append(args, "--folder-uri", uri)
+	}
+}
+
+// OpenURLCommand returns the command that opens url with the handler
+// registered on the system.
+func OpenURLCommand(url string) (string, []string) {
+	switch runtime.GOOS {
+	case "darwin":
+		return "open", []string{url}
+	case "windows":
+		// unlike `cmd /c start`, & in the url needs no escaping
+		return "rundll32", []string{"url.dll,FileProtocolHandler", url}
+	default:
+		return "xdg-open", []string{url}
+	}
+}
+
+// terminalCommand returns the command that opens a terminal running the
+// remote editor on path over ssh.
+func (e *Editor) terminalCommand(host string, path string, sshOptions []string) (string, []string) {
+	remote := e.RemoteEditor + " " + ShellQuote(path)
+	ssh := append([]string{"ssh"}, sshOptions...)
+	ssh = append(ssh, "-t", host, remote)
+
+	if len(e.Terminal) > 0 {
+		return e.Terminal[0], append(slices.Clone(e.Terminal[1:]), ssh...)
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		quoted := make([]string, len(ssh))
+		for i, arg := range ssh {
+			quoted[i] = ShellQuote(arg)
+		}
+
+		script := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(strings.Join(quoted, " "))
+		return "osascript", []string{
+			"-e", `tell application "Terminal" to activate`,
+			"-e", fmt.Sprintf(`tell application "Terminal" to do script "%s"`, script),
+		}
+	case "windows":
+		// not `cmd /c start`, cmd.exe would interpret & and | in the path
+		// despite the quoting of the args
+		return "conhost.exe", ssh
+	default:
+		return "x-terminal-emulator", append([]string{"-e"}, ssh...)
+	}
+}
+
+// ShellQuote quotes value for a POSIX shell.
+func ShellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/xingty/rcode-go/pkg/utils/rotate"
+)
+
+var GCODE_LOG_DIR = filepath.Join(GCODE_HOME, "logs")
+
+// LOG_LEVEL is the level of the default logger, it changes on reload.
+var LOG_LEVEL = new(slog.LevelVar)
+
+// InitLogger sends the default logger, and the std logger with it, to
+// ~/.gcode/logs/<name>.log. Each binary logs to its own file.
+func InitLogger(name string) {
+	settings, err := LoadSettings(GCODE_SETTINGS_FILE)
+	if err != nil {
+		settings = DefaultSettings()
+	}
+
+	var out io.Writer = io.Discard
+	os.MkdirAll(GCODE_LOG_DIR, 0755)
+	file, err := rotate.Open(
+		filepath.Join(GCODE_LOG_DIR, name+".log"),
+		int64(settings.Log.MaxSize)*1024*1024,
+		time.Duration(settings.Log.MaxAge)*24*time.Hour,
+		settings.Log.MaxBackups,
+	)
+	if err == nil {
+		out = file
+	}
+
+	options := &slog.HandlerOptions{Level: LOG_LEVEL}
+	var handler slog.Handler = slog.NewTextHandler(out, options)
+	if settings.Log.Format == LOG_FORMAT_JSON {
+		handler = slog.NewJSONHandler(out, options)
+	}
+
+	ApplyLogLevel(settings)
+	slog.SetDefault(slog.New(handler).With("pid", os.Getpid()))
+}
+
+// ApplyLogLevel sets the level of the default logger from the settings,
+// GCODE_DEBUG forces the debug level.
+func ApplyLogLevel(settings *Settings) {
+	level := settings.Level()
+	if os.Getenv(ENV_DEBUG) != "" {
+		level = slog.LevelDebug
+	}
+
+	LOG_LEVEL.Set(level)
+}
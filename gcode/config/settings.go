@@ -0,0 +1,203 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+var GCODE_SETTINGS_FILE = filepath.Join(GCODE_HOME, "config.json")
+
+var LOG_LEVELS = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+const (
+	LOG_FORMAT_TEXT = "text"
+	LOG_FORMAT_JSON = "json"
+)
+
+// Settings is the configuration of gssh-ipc, loaded from config.json. It is
+// re-read on SIGHUP and on `gssh-ipc reload`.
+type Settings struct {
+	// Editors that may be opened from the remote, all editors of the
+	// registry by default
+	Editors  []string     `json:"editors"`
+	Idle     IdleSettings `json:"idle"`
+	LogLevel string       `json:"log_level"`
+	Log      LogSettings  `json:"log"`
+	Policies Policies     `json:"policies"`
+
+	// Registry is loaded from editors.json along with the settings
+	Registry Editors `json:"-"`
+}
+
+// LogSettings are applied when a binary starts, unlike log_level they are
+// not reloaded.
+type LogSettings struct {
+	Format string `json:"format"`
+	// rotate the log file once it grows past max_size MB
+	MaxSize int `json:"max_size"`
+	// remove rotated files older than max_age days
+	MaxAge int `json:"max_age"`
+	// keep at most max_backups rotated files
+	MaxBackups int `json:"max_backups"`
+}
+
+// IdleSettings override the idle flags of gssh-ipc, in seconds like the
+// flags. 0 disables the rule, a missing value keeps the flag.
+type IdleSettings struct {
+	NoSessions *int `json:"no_sessions"`
+	NoRPCs     *int `json:"no_rpcs"`
+}
+
+// IdlePolicy decides when gssh-ipc exits on its own. A zero duration
+// disables the rule, the zero value never exits.
+type IdlePolicy struct {
+	// exit after no session has been alive for this long
+	NoSessions time.Duration
+	// exit after no request has been received for this long
+	NoRPCs time.Duration
+}
+
+type Policies struct {
+	// AllowedHosts are glob patterns of the hostnames gssh may create
+	// sessions for, all hosts are allowed when empty
+	AllowedHosts []string `json:"allowed_hosts"`
+}
+
+func DefaultSettings() *Settings {
+	registry := BuiltinEditors()
+	return &Settings{
+		Editors:  registry.Names(),
+		Registry: registry,
+		LogLevel: "info",
+		Log: LogSettings{
+			Format:     LOG_FORMAT_TEXT,
+			MaxSize:    10,
+			MaxAge:     7,
+			MaxBackups: 5,
+		},
+	}
+}
+
+// LoadSettings reads the settings from file, missing fields keep their
+// defaults. A missing file yields the default settings.
+func LoadSettings(file string) (*Settings, error) {
+	registry, err := LoadEditors(GCODE_EDITORS_FILE)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := DefaultSettings()
+	settings.Registry = registry
+	settings.Editors = registry.Names()
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return settings, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, settings)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", file, err)
+	}
+
+	err = settings.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", file, err)
+	}
+
+	return settings, nil
+}
+
+func (s *Settings) Validate() error {
+	if len(s.Editors) == 0 {
+		return fmt.Errorf("editors must not be empty")
+	}
+
+	for _, name := range s.Editors {
+		if _, ok := s.Registry[name]; !ok {
+			return fmt.Errorf("unknown editor: %s", name)
+		}
+	}
+
+	for name, seconds := range map[string]*int{"no_sessions": s.Idle.NoSessions, "no_rpcs": s.Idle.NoRPCs} {
+		if seconds != nil && *seconds < 0 {
+			return fmt.Errorf("idle.%s must not be negative", name)
+		}
+	}
+
+	if _, ok := LOG_LEVELS[s.LogLevel]; !ok {
+		return fmt.Errorf("unknown log_level: %s", s.LogLevel)
+	}
+
+	if s.Log.Format != LOG_FORMAT_TEXT && s.Log.Format != LOG_FORMAT_JSON {
+		return fmt.Errorf("unknown log.format: %s", s.Log.Format)
+	}
+
+	if s.Log.MaxSize < 0 || s.Log.MaxAge < 0 || s.Log.MaxBackups < 0 {
+		return fmt.Errorf("log.max_size, log.max_age and log.max_backups must not be negative")
+	}
+
+	for _, pattern := range s.Policies.AllowedHosts {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid allowed_hosts pattern %q: %w", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// IdlePolicy returns the idle policy of the flags overridden by the settings.
+func (s *Settings) IdlePolicy(defaults IdlePolicy) IdlePolicy {
+	policy := defaults
+	if s.Idle.NoSessions != nil {
+		policy.NoSessions = time.Duration(*s.Idle.NoSessions) * time.Second
+	}
+
+	if s.Idle.NoRPCs != nil {
+		policy.NoRPCs = time.Duration(*s.Idle.NoRPCs) * time.Second
+	}
+
+	return policy
+}
+
+// Editor returns the editor of the registry if it may be opened.
+func (s *Settings) Editor(name string) (*Editor, bool) {
+	if !slices.Contains(s.Editors, name) {
+		return nil, false
+	}
+
+	editor, ok := s.Registry[name]
+	return editor, ok
+}
+
+func (s *Settings) IsHostAllowed(hostname string) bool {
+	if len(s.Policies.AllowedHosts) == 0 {
+		return true
+	}
+
+	for _, pattern := range s.Policies.AllowedHosts {
+		if ok, _ := path.Match(pattern, hostname); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Settings) Level() slog.Level {
+	return LOG_LEVELS[s.LogLevel]
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var GCODE_WORKSPACES_DIR = filepath.Join(GCODE_HOME, "workspaces")
+
+const WORKSPACE_EXT = ".code-workspace"
+
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+type workspaceFolder struct {
+	Name string `json:"name,omitempty"`
+	URI  string `json:"uri"`
+}
+
+type workspace struct {
+	Folders         []workspaceFolder `json:"folders"`
+	RemoteAuthority string            `json:"remoteAuthority,omitempty"`
+}
+
+// WorkspaceName turns name into a file name, e.g. devbox-api-web for the
+// folders api and web on devbox.
+func WorkspaceName(parts ...string) string {
+	name := strings.Join(parts, "-")
+	return strings.Trim(unsafeNameChars.ReplaceAllString(name, "-"), "-.")
+}
+
+func WorkspaceFile(name string) string {
+	return filepath.Join(GCODE_WORKSPACES_DIR, WorkspaceName(name)+WORKSPACE_EXT)
+}
+
+// WriteWorkspace writes a multi-root workspace of the folders of target to
+// ~/.gcode/workspaces and returns its path.
+func (e *Editor) WriteWorkspace(name string, target Target) (string, error) {
+	if WorkspaceName(name) == "" {
+		return "", fmt.Errorf("invalid workspace name: %s", name)
+	}
+
+	ws := workspace{
+		Folders:         make([]workspaceFolder, 0, len(target.Folders)),
+		RemoteAuthority: e.RemoteAuthority(target.Host),
+	}
+	for _, folder := range target.Folders {
+		ws.Folders = append(ws.Folders, workspaceFolder{
+			Name: path.Base(folder),
+			URI:  e.URI(Target{Host: target.Host, Path: folder}),
+		})
+	}
+
+	data, err := json.MarshalIndent(ws, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(GCODE_WORKSPACES_DIR, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	file := WorkspaceFile(name)
+	return file, os.WriteFile(file, data, 0644)
+}
+
+// ListWorkspaces returns the names of the saved workspaces.
+func ListWorkspaces() []string {
+	matches, _ := filepath.Glob(filepath.Join(GCODE_WORKSPACES_DIR, "*"+WORKSPACE_EXT))
+	names := make([]string, 0, len(matches))
+	for _, match := range matches {
+		names = append(names, strings.TrimSuffix(filepath.Base(match), WORKSPACE_EXT))
+	}
+
+	slices.Sort(names)
+	return names
+}
+
+// LocalFileURI returns the file:// URI of a local path.
+func LocalFileURI(path string) string {
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		// C:/Users/... on windows
+		path = "/" + path
+	}
+
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
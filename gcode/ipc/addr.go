@@ -0,0 +1,28 @@
+package ipc
+
+import (
+	"net"
+	"strings"
+)
+
+// IsLoopbackHost reports whether host only resolves to loopback addresses.
+// IPv6 literals may be given with or without brackets.
+func IsLoopbackHost(host string) bool {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsLoopback()
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return false
+	}
+
+	for _, ip := range ips {
+		if !ip.IsLoopback() {
+			return false
+		}
+	}
+
+	return true
+}
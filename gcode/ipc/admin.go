@@ -0,0 +1,246 @@
+package ipc
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xingty/rcode-go/gcode/config"
+	"github.com/xingty/rcode-go/pkg/models"
+	"github.com/xingty/rcode-go/pkg/utils"
+)
+
+var ADMIN_COMMANDS = utils.NewSet("status", "stop", "restart", "reload", "sessions")
+
+var ErrNotRunning = errors.New("gssh-ipc is not running")
+
+// Admin serves the admin methods of the running server.
+func (s *IPCServerSocket) Admin(action string) (any, error) {
+	slog.Info("admin", "action", action)
+
+	switch action {
+	case "status":
+		active, _ := s.getSessions()
+		status := models.ServerStatus{
+			Pid:        os.Getpid(),
+			Addr:       s.listener.Addr().String(),
+			Started:    s.started.Unix(),
+			Sessions:   len(active),
+			ShutdownIn: -1,
+			Args:       os.Args[1:],
+		}
+		if remaining, reason, ok := s.timeToShutdown(); ok {
+			status.ShutdownIn = int64(remaining.Seconds())
+			status.ShutdownReason = reason
+		}
+
+		return status, nil
+
+	case "sessions":
+		active, _ := s.getSessions()
+		activeSet := utils.NewSet(active...)
+		sessions := make([]models.SessionInfo, 0)
+		for sid, session := range s.handler.Sessions() {
+			sessions = append(sessions, models.SessionInfo{
+				Sid:      sid,
+				Hostname: session.Hostname,
+				Pid:      session.Pid,
+				Parent:   session.Parent,
+				Created:  session.Created.Unix(),
+				Active:   activeSet.Has(sid),
+			})
+		}
+
+		return sessions, nil
+
+	case "stop":
+		// stop after the response is written, the drain waits for it
+		go s.Stop()
+		return "stopping", nil
+
+	case "reload":
+		return "reloaded", s.Reload()
+	}
+
+	return nil, fmt.Errorf("unknown admin action: %s", action)
+}
+
+// Reload re-reads the configuration of the server without dropping sessions.
+// An invalid configuration is rejected and the current one is kept.
+func (s *IPCServerSocket) Reload() error {
+	settings, err := config.LoadSettings(config.GCODE_SETTINGS_FILE)
+	if err != nil {
+		slog.Error("reload failed, keeping the current config", "error", err)
+		return err
+	}
+
+	s.handler.SetSettings(settings)
+	config.ApplyLogLevel(settings)
+	policy := s.idlePolicy()
+	slog.Info(
+		"config loaded",
+		"editors", settings.Editors,
+		"idle.no_sessions", policy.NoSessions,
+		"idle.no_rpcs", policy.NoRPCs,
+		"log_level", settings.LogLevel,
+		"allowed_hosts", settings.Policies.AllowedHosts,
+	)
+	s.reschedule()
+
+	return nil
+}
+
+func callAdmin(endpoint *Endpoint, action string) (json.RawMessage, error) {
+	key, err := os.ReadFile(config.GCODE_KEY_FILE)
+	if err != nil {
+		return nil, err
+	}
+
+	params := models.AdminParams{
+		Keyfile: string(key),
+		Action:  action,
+	}
+
+	return Call(endpoint.Network, endpoint.Addr, "admin", params)
+}
+
+// RunAdminCommand runs an admin command against the running gssh-ipc and
+// prints the result. args are the arguments of the command.
+func RunAdminCommand(action string, args []string) error {
+	endpoint, err := ReadEndpoint()
+	if err != nil {
+		// a socket activated server is started by the request itself,
+		// which is pointless for stop and restart
+		var ok bool
+		endpoint, ok = ServiceEndpoint()
+		if !ok || action == "stop" || action == "restart" {
+			return ErrNotRunning
+		}
+	}
+
+	if action == "restart" {
+		flags := flag.NewFlagSet("restart", flag.ExitOnError)
+		force := flags.Bool("force", false, "Restart even if there are active sessions")
+		flags.Parse(args)
+
+		return restart(endpoint, *force)
+	}
+
+	data, err := callAdmin(endpoint, action)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "status":
+		status := models.ServerStatus{}
+		json.Unmarshal(data, &status)
+		started := time.Unix(status.Started, 0)
+		fmt.Printf("pid:      %d\n", status.Pid)
+		fmt.Printf("address:  %s\n", status.Addr)
+		fmt.Printf("uptime:   %s\n", time.Since(started).Round(time.Second))
+		fmt.Printf("sessions: %d\n", status.Sessions)
+		if status.ShutdownIn < 0 {
+			fmt.Println("shutdown: never")
+		} else {
+			shutdownIn := time.Duration(status.ShutdownIn) * time.Second
+			fmt.Printf("shutdown: in %s (%s)\n", shutdownIn, status.ShutdownReason)
+		}
+
+	case "sessions":
+		sessions := make([]models.SessionInfo, 0)
+		json.Unmarshal(data, &sessions)
+		sort.Slice(sessions, func(i, j int) bool {
+			return sessions[i].Created < sessions[j].Created
+		})
+
+		for _, session := range sessions {
+			state := "active"
+			if !session.Active {
+				state = "inactive"
+			}
+
+			created := time.Unix(session.Created, 0).Format(time.DateTime)
+			fmt.Printf("%s  %-8s  %s  %s\n", session.Sid, state, created, session.Hostname)
+		}
+
+	case "stop":
+		// the instance lock is released once the server has exited
+		lock, err := Lock(config.GSSH_IPC_LOCK_FILE, true)
+		if err != nil {
+			return err
+		}
+		lock.Unlock()
+		fmt.Println("stopped")
+
+	default:
+		var message string
+		json.Unmarshal(data, &message)
+		fmt.Println(message)
+	}
+
+	return nil
+}
+
+// restart stops the running server and starts a new one with the same
+// arguments on the same address. Sessions are lost, so it is refused while
+// there are active ones unless force is set.
+func restart(endpoint *Endpoint, force bool) error {
+	data, err := callAdmin(endpoint, "status")
+	if err != nil {
+		return err
+	}
+
+	status := models.ServerStatus{}
+	json.Unmarshal(data, &status)
+	if status.Sessions > 0 {
+		if !force {
+			return fmt.Errorf("%d active session(s) would be dropped, use restart -force", status.Sessions)
+		}
+
+		fmt.Printf("Warning: dropping %d active session(s)\n", status.Sessions)
+	}
+
+	host, port, err := net.SplitHostPort(endpoint.Addr)
+	if err != nil {
+		return err
+	}
+
+	err = RunAdminCommand("stop", nil)
+	if err != nil {
+		return err
+	}
+
+	addr, err := StartIPCServer(os.Args[0], withAddr(status.Args, host, port))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("started on %s\n", addr)
+	return nil
+}
+
+// withAddr replaces -host and -port in the server arguments args.
+func withAddr(args []string, host string, port string) []string {
+	result := make([]string, 0, len(args)+4)
+	for i := 0; i < len(args); i++ {
+		name, _, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		if strings.HasPrefix(args[i], "-") && (name == "host" || name == "port") {
+			if !hasValue {
+				i++
+			}
+			continue
+		}
+
+		result = append(result, args[i])
+	}
+
+	return append(result, "-host", host, "-port", port)
+}
@@ -0,0 +1,138 @@
+package ipc
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/xingty/rcode-go/gcode/config"
+)
+
+// interval to reap the sessions whose ssh client has exited
+const REAP_INTERVAL = 10 * time.Second
+
+// begin records a request in flight. The server doesn't idle out while a
+// request, e.g. open_ide with --wait, is in flight.
+func (s *IPCServerSocket) begin() {
+	s.activity.Lock()
+	s.pending++
+	s.activity.Unlock()
+}
+
+// end records the end of a request, it reschedules the idle shutdown.
+func (s *IPCServerSocket) end() {
+	s.activity.Lock()
+	s.pending--
+	s.lastRPC = time.Now()
+	s.activity.Unlock()
+
+	s.reschedule()
+}
+
+// reschedule wakes up the scheduler to recompute the shutdown time.
+func (s *IPCServerSocket) reschedule() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// idlePolicy returns the policy in effect, a private server never idles out.
+func (s *IPCServerSocket) idlePolicy() config.IdlePolicy {
+	if s.private {
+		return config.IdlePolicy{}
+	}
+
+	return s.handler.Settings().IdlePolicy(s.idleDefaults)
+}
+
+// busy reports whether requests are in flight, the server is due to idle out
+// once they end.
+func (s *IPCServerSocket) busy() bool {
+	s.activity.Lock()
+	defer s.activity.Unlock()
+
+	return s.pending > 0
+}
+
+// timeToShutdown returns how long until the server exits on its own and the
+// rule that triggers it. ok is false if the server never idles out.
+func (s *IPCServerSocket) timeToShutdown() (remaining time.Duration, reason string, ok bool) {
+	policy := s.idlePolicy()
+
+	s.activity.Lock()
+	defer s.activity.Unlock()
+
+	now := time.Now()
+	if policy.NoSessions > 0 && s.sessions == 0 {
+		remaining = s.lastSession.Add(policy.NoSessions).Sub(now)
+		reason, ok = "no sessions", true
+	}
+
+	if policy.NoRPCs > 0 {
+		left := s.lastRPC.Add(policy.NoRPCs).Sub(now)
+		if !ok || left < remaining {
+			remaining, reason, ok = left, "no rpcs", true
+		}
+	}
+
+	return max(remaining, 0), reason, ok
+}
+
+// reap destroys the sessions whose ssh client has exited.
+func (s *IPCServerSocket) reap() {
+	activeSessions, inactiveSessions := s.getSessions()
+	slog.Debug("reap", "active", len(activeSessions), "inactive", len(inactiveSessions))
+
+	for _, sid := range inactiveSessions {
+		slog.Info("destroy session", "sid", sid)
+		s.handler.DestroySession(sid)
+	}
+
+	s.activity.Lock()
+	if len(activeSessions) > 0 || s.sessions > 0 {
+		s.lastSession = time.Now()
+	}
+	s.sessions = len(activeSessions)
+	s.activity.Unlock()
+}
+
+// schedule reaps sessions and stops the server once the idle policy is due.
+func (s *IPCServerSocket) schedule() {
+	ticker := time.NewTicker(REAP_INTERVAL)
+	defer ticker.Stop()
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	lastReason := "-"
+	for {
+		s.reap()
+
+		timer.Stop()
+		remaining, reason, ok := s.timeToShutdown()
+		if ok && remaining > 0 {
+			timer.Reset(remaining)
+		} else if ok && !s.busy() {
+			slog.Info("idle shutdown", "reason", reason)
+			s.Stop()
+			return
+		}
+
+		if reason != lastReason {
+			if ok {
+				slog.Info("idle shutdown scheduled", "in", remaining.Round(time.Second), "reason", reason)
+			} else {
+				slog.Info("idle shutdown disabled")
+			}
+			lastReason = reason
+		}
+
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package ipc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/xingty/rcode-go/gcode/config"
+)
+
+// set by StartIPCServer, gssh-ipc reports readiness on stdout when present
+const ENV_NOTIFY_READY = "GSSH_IPC_NOTIFY_READY"
+
+const READY_TIMEOUT = 5 * time.Second
+
+var ErrLocked = errors.New("file is locked")
+var ErrAlreadyRunning = errors.New("gssh-ipc is already running")
+
+// FileLock is an exclusive advisory lock on a file. The lock is released by
+// the OS when the owning process exits, so it never goes stale.
+type FileLock struct {
+	file *os.File
+}
+
+// Lock locks the file at path, creating it if needed. Without blocking it
+// fails with ErrLocked when the lock is held by another process.
+func Lock(path string, blocking bool) (*FileLock, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = lockFile(file, blocking)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &FileLock{file: file}, nil
+}
+
+func (l *FileLock) Unlock() error {
+	defer l.file.Close()
+	return unlockFile(l.file)
+}
+
+// Endpoint is published by the running gssh-ipc in the runtime file.
+type Endpoint struct {
+	Pid     int    `json:"pid"`
+	Network string `json:"network"`
+	Addr    string `json:"addr"`
+}
+
+// ReadEndpoint returns the endpoint published by the running gssh-ipc.
+func ReadEndpoint() (*Endpoint, error) {
+	data, err := os.ReadFile(config.GSSH_IPC_RUNTIME_FILE)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := &Endpoint{}
+	err = json.Unmarshal(data, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return endpoint, nil
+}
+
+func writeEndpoint(addr net.Addr) error {
+	data, _ := json.Marshal(Endpoint{
+		Pid:     os.Getpid(),
+		Network: addr.Network(),
+		Addr:    addr.String(),
+	})
+
+	// write to a temporary file first, readers never see a partial file
+	tmp := config.GSSH_IPC_RUNTIME_FILE + ".tmp"
+	err := os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, config.GSSH_IPC_RUNTIME_FILE)
+}
+
+func removeEndpoint() {
+	os.Remove(config.GSSH_IPC_RUNTIME_FILE)
+}
+
+// AcquireInstance takes the lock held by gssh-ipc for its whole lifetime.
+// It fails with ErrAlreadyRunning if another instance owns the lock.
+func AcquireInstance() (*FileLock, error) {
+	lock, err := Lock(config.GSSH_IPC_LOCK_FILE, false)
+	if !errors.Is(err, ErrLocked) {
+		return lock, err
+	}
+
+	endpoint, err := ReadEndpoint()
+	if err != nil {
+		return nil, fmt.Errorf("%w (starting up)", ErrAlreadyRunning)
+	}
+
+	state := "not responding"
+	sock := NewIPCClientSocket(endpoint.Addr)
+	if sock.Connect(endpoint.Network) == nil {
+		sock.Close()
+		state = "listening on " + endpoint.Addr
+	}
+
+	return nil, fmt.Errorf("%w (pid %d, %s)", ErrAlreadyRunning, endpoint.Pid, state)
+}
+
+// notifyReady tells the gssh that spawned this server that it is accepting
+// connections. stdout is discarded afterwards as the reader goes away.
+func notifyReady(addr net.Addr) {
+	if os.Getenv(ENV_NOTIFY_READY) == "" {
+		return
+	}
+
+	fmt.Fprintf(os.Stdout, "ready %s\n", addr.String())
+	err := discardStdout()
+	if err != nil {
+		slog.Warn("failed to discard stdout", "error", err)
+	}
+}
+
+// startAndWait starts the server command and blocks until it reports that
+// it is ready. It returns the address the server listens on.
+func startAndWait(cmd *exec.Cmd) (string, error) {
+	cmd.Env = append(os.Environ(), ENV_NOTIFY_READY+"=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return "", err
+	}
+	defer stdout.Close()
+
+	type result struct {
+		line string
+		err  error
+	}
+
+	ready := make(chan result, 1)
+	go func() {
+		line, err := bufio.NewReader(stdout).ReadString('\n')
+		ready <- result{line, err}
+	}()
+
+	select {
+	case r := <-ready:
+		addr, ok := strings.CutPrefix(strings.TrimSpace(r.line), "ready ")
+		if r.err != nil || !ok {
+			cmd.Wait()
+			return "", errors.New("gssh-ipc exited before it was ready")
+		}
+
+		cmd.Process.Release()
+		return addr, nil
+	case <-time.After(READY_TIMEOUT):
+		cmd.Process.Kill()
+		cmd.Wait()
+		return "", errors.New("timed out waiting for gssh-ipc")
+	}
+}
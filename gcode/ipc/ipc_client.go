@@ -2,17 +2,45 @@ package ipc
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"log/slog"
 	"net"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/xingty/rcode-go/gcode/config"
 	"github.com/xingty/rcode-go/pkg/models"
 )
 
+const CONNECT_TIMEOUT = 5 * time.Second
+
 type IPCClientSocket struct {
 	addr string
 	conn net.Conn
 }
 
+// SessionSocket returns the path of the unix socket gssh forwards to the
+// remote host for the given session.
+func SessionSocket(sid string) string {
+	return fmt.Sprintf("/tmp/rssh-ipc-%s.sock", sid)
+}
+
+// SessionAddr returns the network and address of the IPC endpoint forwarded
+// into the current gssh session. gssh exports RSSH_ADDR when it falls back to
+// tcp forwarding, otherwise the session's unix socket is used.
+func SessionAddr(sid string) (string, string) {
+	if addr := os.Getenv(config.ENV_RSSH_ADDR); addr != "" {
+		if network, address, ok := strings.Cut(addr, "://"); ok {
+			return network, address
+		}
+	}
+
+	return "unix", SessionSocket(sid)
+}
+
 func NewIPCClientSocket(addr string) *IPCClientSocket {
 	return &IPCClientSocket{addr: addr, conn: nil}
 }
@@ -22,7 +50,7 @@ func (s *IPCClientSocket) Connect(network string) error {
 		return errors.New("already connected")
 	}
 
-	conn, err := net.Dial(network, s.addr)
+	conn, err := net.DialTimeout(network, s.addr, CONNECT_TIMEOUT)
 	if err != nil {
 		return errors.New("failed to connect to RPC server")
 	}
@@ -31,6 +59,15 @@ func (s *IPCClientSocket) Connect(network string) error {
 	return nil
 }
 
+// SetDeadline sets the read and write deadline of the connection.
+func (s *IPCClientSocket) SetDeadline(t time.Time) error {
+	if s.conn == nil {
+		return errors.New("not connected")
+	}
+
+	return s.conn.SetDeadline(t)
+}
+
 func (s *IPCClientSocket) Send(data []byte) error {
 	if s.conn == nil {
 		return errors.New("not connected")
@@ -90,3 +127,51 @@ func (s *IPCClientSocket) Read(b []byte) (int, error) {
 
 	return s.conn.Read(b)
 }
+
+// Call sends a single request to the IPC server at addr and returns the data
+// of the response. A non-zero response code is returned as an error.
+func Call(network string, addr string, method string, params any) (json.RawMessage, error) {
+	slog.Debug("rpc", "method", method, "network", network, "addr", addr)
+	sock := NewIPCClientSocket(addr)
+	err := sock.Connect(network)
+	if err != nil {
+		return nil, err
+	}
+	defer sock.Close()
+
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(models.MessagePayload{
+		Method: method,
+		Params: rawParams,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = sock.Send(data)
+	if err != nil {
+		return nil, err
+	}
+
+	resData, err := sock.Receive()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &models.ResponsePayload[json.RawMessage]{}
+	err = json.Unmarshal(resData, res)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Code != 0 {
+		slog.Warn("rpc rejected", "method", method, "message", res.Message)
+		return nil, errors.New(res.Message)
+	}
+
+	return res.Data, nil
+}
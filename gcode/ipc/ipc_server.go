@@ -3,11 +3,13 @@ package ipc
 import (
 	"bytes"
 	"errors"
-	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -18,20 +20,49 @@ import (
 	"github.com/xingty/rcode-go/pkg/utils"
 )
 
+// max time to wait for in-flight requests when the server stops
+const DRAIN_TIMEOUT = 10 * time.Second
+
+// max time for a client to send its request after connecting
+const READ_TIMEOUT = 5 * time.Second
+
 type IPCServerSocket struct {
-	handler     *MessageHandler
-	maxIdleTime int
-	idle        int
-	done        chan struct{}
+	handler      *MessageHandler
+	idleDefaults config.IdlePolicy
+	done         chan struct{}
+	doneOnce     sync.Once
+	// stopping is set by Stop under inflightLock, no request is added to
+	// inflight afterwards
+	inflightLock sync.Mutex
+	stopping     bool
+	inflight     sync.WaitGroup
+	listener     net.Listener
+	started      time.Time
+	private      bool
+	wake         chan struct{}
+
+	activity    sync.Mutex
+	sessions    int
+	pending     int
+	lastSession time.Time
+	lastRPC     time.Time
 }
 
-func NewIPCServerSocket(maxIdleTime int) *IPCServerSocket {
-	return &IPCServerSocket{
-		handler:     NewMessageHandler(),
-		maxIdleTime: maxIdleTime,
-		idle:        0,
-		done:        make(chan struct{}),
+// NewIPCServerSocket creates a server that idles out by idlePolicy, unless
+// the settings override it.
+func NewIPCServerSocket(idlePolicy config.IdlePolicy) *IPCServerSocket {
+	now := time.Now()
+	s := &IPCServerSocket{
+		handler:      NewMessageHandler(),
+		idleDefaults: idlePolicy,
+		done:         make(chan struct{}),
+		wake:         make(chan struct{}, 1),
+		lastSession:  now,
+		lastRPC:      now,
 	}
+	s.handler.admin = s
+
+	return s
 }
 
 func (s *IPCServerSocket) handleClient(conn net.Conn) error {
@@ -40,6 +71,9 @@ func (s *IPCServerSocket) handleClient(conn net.Conn) error {
 	buf := make([]byte, 0)
 	defer conn.Close()
 
+	// a client that sends nothing must neither keep the server alive nor
+	// hold up its shutdown
+	conn.SetReadDeadline(time.Now().Add(READ_TIMEOUT))
 	for {
 		n, err := conn.Read(data)
 		if err != nil {
@@ -53,9 +87,18 @@ func (s *IPCServerSocket) handleClient(conn net.Conn) error {
 		index := bytes.Index(data, delimiter)
 		if index != -1 {
 			buf = append(buf, data[:index]...)
+			conn.SetReadDeadline(time.Time{})
+			if !s.track() {
+				conn.Write(models.NewRawResponse(1, "", "server is stopping"))
+				return errors.New("server is stopping")
+			}
+			defer s.inflight.Done()
+			s.begin()
+			defer s.end()
+
 			data, err := s.handler.HandleMessage(buf)
 			if err != nil {
-				log.Printf("%s", err.Error())
+				slog.Warn("request failed", "error", err)
 				rawData := models.NewRawResponse(1, "", err.Error())
 				conn.Write(rawData)
 				return err
@@ -72,62 +115,17 @@ func (s *IPCServerSocket) handleClient(conn net.Conn) error {
 }
 
 func (s *IPCServerSocket) handleConnection(listener net.Listener) {
-	defer close(s.done)
-
-	idle := 0
+	defer s.Stop()
 
 	for {
-		tcpListener, ok := listener.(*net.TCPListener)
-		if !ok {
-			log.Fatal("could not cast listener to TCPListener")
-		}
-
-		tcpListener.SetDeadline(time.Now().Add(10 * time.Second))
-		conn, err := tcpListener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
 			if errors.Is(err, net.ErrClosed) {
-				log.Println("connection closed, server stopped")
+				slog.Info("listener closed")
 				return
 			}
 
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				activeSessions, inactiveSessions := s.getSessions()
-				clients := len(activeSessions) + len(inactiveSessions)
-				if os.Getenv(config.ENV_DEBUG) != "" {
-					log.Printf(
-						"active: %d, inactive: %d, idle=%d\n",
-						len(activeSessions), len(inactiveSessions), idle,
-					)
-				}
-
-				if len(inactiveSessions) > 0 {
-					for _, sid := range inactiveSessions {
-						log.Printf("destroy session: %s\n", sid)
-						s.handler.DestroySession(sid)
-					}
-
-					log.Printf(
-						"Server state: clients %d idle %d",
-						clients-len(inactiveSessions), idle,
-					)
-				}
-
-				if clients > 0 {
-					idle = 0
-				} else {
-					idle += 10
-				}
-
-				if clients == 0 && idle > s.maxIdleTime {
-					log.Printf(
-						"Server stopped: clients %d, idle %d", clients, idle,
-					)
-					return
-				}
-
-				continue
-			}
-			log.Printf("error ocurred while accepting connection: %v", err)
+			slog.Error("failed to accept connection", "error", err)
 			continue
 		}
 
@@ -135,8 +133,22 @@ func (s *IPCServerSocket) handleConnection(listener net.Listener) {
 	}
 }
 
+// track adds a request to the in-flight ones, unless the server is stopping.
+// A connection counts as a request once its message is read.
+func (s *IPCServerSocket) track() bool {
+	s.inflightLock.Lock()
+	defer s.inflightLock.Unlock()
+
+	if s.stopping {
+		return false
+	}
+
+	s.inflight.Add(1)
+	return true
+}
+
 func (s *IPCServerSocket) getSessions() ([]string, []string) {
-	curSessions := s.handler.sessions
+	curSessions := s.handler.Sessions()
 	activeSessions := make([]string, 0)
 	inactiveSessions := make([]string, 0)
 	if len(curSessions) == 0 {
@@ -149,8 +161,18 @@ func (s *IPCServerSocket) getSessions() ([]string, []string) {
 	}
 
 	pidSet := utils.NewSet(pids...)
+	var isActive func(session *Session) bool
+	isActive = func(session *Session) bool {
+		if session.Parent == "" {
+			return pidSet.Has(session.Pid)
+		}
+
+		parent, ok := curSessions[session.Parent]
+		return ok && isActive(parent)
+	}
+
 	for sid, session := range curSessions {
-		if pidSet.Has(session.Pid) {
+		if isActive(session) {
 			activeSessions = append(activeSessions, sid)
 		} else {
 			inactiveSessions = append(inactiveSessions, sid)
@@ -160,29 +182,104 @@ func (s *IPCServerSocket) getSessions() ([]string, []string) {
 	return activeSessions, inactiveSessions
 }
 
+func Listen(host string, port int) (net.Listener, error) {
+	addr := net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(port))
+	return net.Listen("tcp", addr)
+}
+
 func (s *IPCServerSocket) Start(host string, port int) error {
+	listener, err := Listen(host, port)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(listener)
+}
+
+// Serve accepts connections on listener until the server is stopped. The
+// endpoint is published in the runtime file so gssh can discover it.
+func (s *IPCServerSocket) Serve(listener net.Listener) error {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
 
-	addr := fmt.Sprintf("%s:%d", host, port)
-	listener, err := net.Listen("tcp", addr)
+	s.listener = listener
+	s.started = time.Now()
+	defer listener.Close()
+	slog.Info("server listening", "addr", listener.Addr().String())
+
+	err := writeEndpoint(listener.Addr())
 	if err != nil {
-		return err
+		slog.Warn("failed to write runtime file", "error", err)
 	}
-	defer listener.Close()
-	log.Println("Server listening on ", listener.Addr())
+	defer removeEndpoint()
+	notifyReady(listener.Addr())
 
 	go s.handleConnection(listener)
+	go s.schedule()
+
+	for running := true; running; {
+		select {
+		case <-hupChan:
+			s.Reload()
+		case <-sigChan:
+			running = false
+		case <-s.done:
+			running = false
+		}
+	}
+
+	s.shutdown()
+	return nil
+}
+
+// ServePrivate accepts connections on listener like Serve, but the server
+// is neither published nor bound to signals. gssh uses it to serve its own
+// session in-process.
+func (s *IPCServerSocket) ServePrivate(listener net.Listener) error {
+	s.listener = listener
+	s.started = time.Now()
+	s.private = true
+	slog.Info("private server listening", "addr", listener.Addr().String())
+
+	go s.handleConnection(listener)
+	go s.schedule()
+	<-s.done
+
+	s.shutdown()
+	return nil
+}
+
+// shutdown stops accepting connections and waits for in-flight requests.
+func (s *IPCServerSocket) shutdown() {
+	s.Stop()
+	s.listener.Close()
+
+	drained := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(drained)
+	}()
 
 	select {
-	case <-sigChan:
-		return nil
-	case <-s.done:
-		return nil
+	case <-drained:
+		slog.Info("server stopped")
+	case <-time.After(DRAIN_TIMEOUT):
+		slog.Warn("server stopped with requests in flight")
 	}
 }
 
+// Stop makes Serve return after in-flight requests are drained. It is safe
+// to call it more than once.
 func (s *IPCServerSocket) Stop() error {
-	defer close(s.done)
+	s.doneOnce.Do(func() {
+		s.inflightLock.Lock()
+		s.stopping = true
+		s.inflightLock.Unlock()
+
+		close(s.done)
+	})
+
 	return nil
 }
@@ -0,0 +1,46 @@
+package ipc
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xingty/rcode-go/gcode/config"
+)
+
+func TestSilentClientIsNotARequest(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewIPCServerSocket(config.IdlePolicy{})
+	lastRPC := s.lastRPC
+	stopped := make(chan struct{})
+	go func() {
+		s.ServePrivate(listener)
+		close(stopped)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	time.Sleep(100 * time.Millisecond)
+	if s.busy() {
+		t.Errorf("a connection that sent nothing is pending")
+	}
+
+	s.Stop()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("shutdown waits for a connection that sent nothing")
+	}
+
+	if !s.lastRPC.Equal(lastRPC) {
+		t.Errorf("a connection that sent nothing updated lastRPC")
+	}
+}
@@ -0,0 +1,47 @@
+//go:build !windows
+// +build !windows
+
+package ipc
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// first fd passed by the service manager
+const LISTEN_FDS_START = 3
+
+// ActivationListeners returns the listeners passed by systemd with the
+// LISTEN_FDS protocol, or nil if the process wasn't socket activated.
+func ActivationListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+
+	// not meant for the children of this process
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, count)
+	for fd := LISTEN_FDS_START; fd < LISTEN_FDS_START+count; fd++ {
+		file := os.NewFile(uintptr(fd), fmt.Sprintf("LISTEN_FD_%d", fd))
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("fd %d is not a listening socket: %w", fd, err)
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
@@ -0,0 +1,107 @@
+//go:build !windows
+// +build !windows
+
+package ipc
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestActivationHelper runs in a child process started by runActivated,
+// which passes the fd the way systemd does. LISTEN_PID=self stands for the
+// pid of the child, which isn't known before it starts.
+func TestActivationHelper(t *testing.T) {
+	if os.Getenv("GCODE_TEST_ACTIVATION") != "1" {
+		t.Skip("only run as a child of TestActivationListeners")
+	}
+
+	if os.Getenv("LISTEN_PID") == "self" {
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	}
+
+	listeners, err := ActivationListeners()
+	if err != nil {
+		fmt.Printf("error=%s\n", err)
+		os.Exit(0)
+	}
+
+	fmt.Printf("listeners=%d\n", len(listeners))
+	for _, listener := range listeners {
+		fmt.Printf("addr=%s\n", listener.Addr())
+	}
+	fmt.Printf("env=%s\n", os.Getenv("LISTEN_FDS"))
+	os.Exit(0)
+}
+
+// runActivated runs TestActivationHelper with file as fd 3.
+func runActivated(t *testing.T, file *os.File, pid string, fds string) string {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestActivationHelper$")
+	cmd.Env = append(os.Environ(), "GCODE_TEST_ACTIVATION=1", "LISTEN_PID="+pid, "LISTEN_FDS="+fds)
+	cmd.ExtraFiles = []*os.File{file}
+
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("helper failed: %s", err)
+	}
+
+	return string(output)
+}
+
+func TestActivationListeners(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	file, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	addr := listener.Addr().String()
+	tests := []struct {
+		name string
+		pid  string
+		fds  string
+		want []string
+	}{
+		{"adopted", "self", "1", []string{"listeners=1", "addr=" + addr, "env=\n"}},
+		{"pid mismatch", "1", "1", []string{"listeners=0", "env=1\n"}},
+		{"no pid", "", "1", []string{"listeners=0"}},
+		{"no fds", "self", "0", []string{"listeners=0"}},
+		{"invalid fds", "self", "x", []string{"listeners=0"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output := runActivated(t, file, test.pid, test.fds)
+			for _, want := range test.want {
+				if !strings.Contains(output, want) {
+					t.Errorf("output %q doesn't contain %q", output, want)
+				}
+			}
+		})
+	}
+}
+
+func TestActivationListenersNotASocket(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	defer writer.Close()
+
+	output := runActivated(t, reader, "self", "1")
+	if !strings.Contains(output, "error=fd 3 is not a listening socket") {
+		t.Errorf("output %q doesn't report the invalid fd", output)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package ipc
+
+import "net"
+
+func ActivationListeners() ([]net.Listener, error) {
+	return nil, nil
+}
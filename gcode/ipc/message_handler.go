@@ -2,16 +2,27 @@ package ipc
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
 
 	"github.com/google/uuid"
 	"github.com/xingty/rcode-go/gcode/config"
 	"github.com/xingty/rcode-go/pkg/models"
 	"github.com/xingty/rcode-go/pkg/utils"
+	"github.com/xingty/rcode-go/pkg/utils/sshconf"
 )
 
 type Session struct {
@@ -19,20 +30,49 @@ type Session struct {
 	Hostname string
 	addr     string
 	Sid      string
+	// Parent is the sid of the session a nested gssh was started from,
+	// a nested session lives as long as its parent.
+	Parent  string
+	Created time.Time
+	skey    string
+	// controlPath is the ControlPath of the ssh master when gssh runs
+	// with -control, forwards are added through it
+	controlPath string
+	forwards    []string
+	sshOptions  []string
+}
+
+// AdminHandler serves the admin methods, which need the state of the server
+type AdminHandler interface {
+	Admin(action string) (any, error)
 }
 
 type MessageHandler struct {
 	sessions map[string]*Session
 	lock     sync.Mutex
+	admin    AdminHandler
+	settings atomic.Pointer[config.Settings]
 }
 
 func NewMessageHandler() *MessageHandler {
-	return &MessageHandler{
+	h := &MessageHandler{
 		sessions: make(map[string]*Session),
 	}
+	h.settings.Store(config.DefaultSettings())
+
+	return h
 }
 
-var rpc_methods = utils.NewSet("open_ide", "new_session")
+func (h *MessageHandler) Settings() *config.Settings {
+	return h.settings.Load()
+}
+
+// SetSettings replaces the settings, sessions are kept.
+func (h *MessageHandler) SetSettings(settings *config.Settings) {
+	h.settings.Store(settings)
+}
+
+var rpc_methods = utils.NewSet("open_ide", "new_session", "nested_session", "forward", "admin")
 
 func (h *MessageHandler) HandleMessage(rawData []byte) (any, error) {
 	message := &models.MessagePayload{}
@@ -55,6 +95,33 @@ func (h *MessageHandler) HandleMessage(rawData []byte) (any, error) {
 
 		return h.NewSession(&sessionParams)
 
+	case "nested_session":
+		var nestedParams models.NestedSessionParams
+		err = json.Unmarshal(message.Params, &nestedParams)
+		if err != nil {
+			return nil, err
+		}
+
+		return h.NestedSession(&nestedParams)
+
+	case "forward":
+		var forwardParams models.ForwardParams
+		err = json.Unmarshal(message.Params, &forwardParams)
+		if err != nil {
+			return nil, err
+		}
+
+		return h.Forward(&forwardParams)
+
+	case "admin":
+		var adminParams models.AdminParams
+		err = json.Unmarshal(message.Params, &adminParams)
+		if err != nil {
+			return nil, err
+		}
+
+		return h.Admin(&adminParams)
+
 	case "open_ide":
 		var ideParsms models.OpenIDEParams
 		err = json.Unmarshal(message.Params, &ideParsms)
@@ -81,17 +148,41 @@ func doValidation(keyfile string, val string) error {
 	return nil
 }
 
+func validateKey(val string) error {
+	err := doValidation(config.GCODE_KEY_FILE, val)
+	if err != nil {
+		return doValidation(config.RSSH_KEY_FILE, val)
+	}
+
+	return nil
+}
+
+func (h *MessageHandler) Admin(params *models.AdminParams) (any, error) {
+	err := validateKey(params.Keyfile)
+	if err != nil {
+		slog.Warn("authentication failed", "method", "admin", "action", params.Action)
+		return nil, err
+	}
+
+	if h.admin == nil {
+		return nil, fmt.Errorf("admin is not supported")
+	}
+
+	return h.admin.Admin(params.Action)
+}
+
 func (h *MessageHandler) NewSession(params *models.SessionParams) (models.SessionData, error) {
 	sid := uuid.New().String()
 	skey := uuid.New().String()
 
-	err := doValidation(config.GCODE_KEY_FILE, params.Keyfile)
+	err := validateKey(params.Keyfile)
 	if err != nil {
-		err := doValidation(config.RSSH_KEY_FILE, params.Keyfile)
-		if err != nil {
-			log.Printf("Authentication failed, key: %s", params.Keyfile)
-			return models.SessionData{}, err
-		}
+		slog.Warn("authentication failed", "method", "new_session", "hostname", params.Hostname)
+		return models.SessionData{}, err
+	}
+
+	if !h.Settings().IsHostAllowed(params.Hostname) {
+		return models.SessionData{}, fmt.Errorf("host not allowed: %s", params.Hostname)
 	}
 
 	data := models.SessionData{
@@ -102,34 +193,352 @@ func (h *MessageHandler) NewSession(params *models.SessionParams) (models.Sessio
 	h.lock.Lock()
 	defer h.lock.Unlock()
 	h.sessions[sid] = &Session{
-		Pid:      params.Pid,
-		Hostname: params.Hostname,
-		Sid:      sid,
+		Pid:         params.Pid,
+		Hostname:    params.Hostname,
+		Sid:         sid,
+		Created:     time.Now(),
+		skey:        skey,
+		controlPath: params.ControlPath,
+		sshOptions:  params.SSHOptions,
 	}
 
 	return data, nil
 }
 
-func (h *MessageHandler) OpenIDE(params *models.OpenIDEParams) (string, error) {
-	if !config.SUPPORTED_IDE.Has(params.Bin) {
-		return "", fmt.Errorf("unsupported ide")
+// NestedSession creates a session for a gssh started inside an existing gssh
+// session. The new host is reached from the local side through an ssh alias
+// that jumps over the parent session's host.
+func (h *MessageHandler) NestedSession(params *models.NestedSessionParams) (models.SessionData, error) {
+	parent, err := h.getSession(params.Sid, params.Skey)
+	if err != nil {
+		return models.SessionData{}, err
+	}
+
+	err = validateDestination(params)
+	if err != nil {
+		return models.SessionData{}, err
+	}
+
+	if !h.Settings().IsHostAllowed(params.Hostname) {
+		return models.SessionData{}, fmt.Errorf("host not allowed: %s", params.Hostname)
+	}
+
+	alias := nestedAlias(parent.Hostname, params.Hostname)
+	err = sshconf.UpsertHost(config.GCODE_SSH_CONFIG, alias, [][2]string{
+		{"HostName", params.Hostname},
+		{"User", params.User},
+		{"Port", params.Port},
+		{"ProxyJump", parent.Hostname},
+	})
+	if err != nil {
+		return models.SessionData{}, err
+	}
+
+	slog.Info("nested session", "hostname", params.Hostname, "parent", parent.Hostname, "alias", alias)
+
+	data := models.SessionData{
+		Sid: uuid.New().String(),
+		Key: uuid.New().String(),
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.sessions[data.Sid] = &Session{
+		Hostname: alias,
+		Sid:      data.Sid,
+		Parent:   parent.Sid,
+		Created:  time.Now(),
+		skey:     data.Key,
+	}
+
+	return data, nil
+}
+
+var (
+	NESTED_HOSTNAME = regexp.MustCompile(`^[A-Za-z0-9_.:][A-Za-z0-9_.:-]*$`)
+	NESTED_USER     = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+)
+
+// validateDestination checks the destination sent by the remote side before
+// it is written into GCODE_SSH_CONFIG. User and port are optional.
+func validateDestination(params *models.NestedSessionParams) error {
+	if !NESTED_HOSTNAME.MatchString(params.Hostname) {
+		return fmt.Errorf("invalid hostname: %q", params.Hostname)
+	}
+
+	if params.User != "" && !NESTED_USER.MatchString(params.User) {
+		return fmt.Errorf("invalid user: %q", params.User)
+	}
+
+	if params.Port != "" {
+		port, err := strconv.Atoi(params.Port)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port: %q", params.Port)
+		}
+	}
+
+	return nil
+}
+
+func nestedAlias(parent string, hostname string) string {
+	name := func(host string) string {
+		return strings.Map(func(r rune) rune {
+			if r == '-' || r == '.' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return r
+			}
+			return '_'
+		}, host)
+	}
+
+	// parent is an alias generated by us when sessions are nested more than once
+	return "gcode-" + strings.TrimPrefix(name(parent), "gcode-") + "-" + name(hostname)
+}
+
+// OpenIDE opens the editor. With --wait the request is kept open until the
+// editor exits, its exit code is returned.
+func (h *MessageHandler) OpenIDE(params *models.OpenIDEParams) (models.OpenIDEResult, error) {
+	result := models.OpenIDEResult{}
+	editor, ok := h.Settings().Editor(params.Bin)
+	if !ok {
+		return result, fmt.Errorf("unsupported ide: %s", params.Bin)
+	}
+
+	session, err := h.getSession(params.Sid, params.Skey)
+	if err != nil {
+		return result, err
+	}
+
+	slog.Info("open ide", "bin", params.Bin, "path", params.Path, "hostname", session.Hostname, "devcontainer", params.DevContainer != nil)
+
+	localFile, err := localPath(params.Local)
+	if err != nil {
+		return result, err
+	}
+
+	target := config.Target{
+		Host:         session.Hostname,
+		Path:         params.Path,
+		SSHOptions:   session.SSHOptions(),
+		Paths:        params.Paths,
+		Files:        params.Files,
+		Folders:      params.Folders,
+		LocalFile:    localFile,
+		DevContainer: params.DevContainer,
+		Options:      params.OpenOptions,
+	}
+	target, err = editor.Prepare(target)
+	if err != nil {
+		return result, err
+	}
+
+	target, err = editor.Resolve(target)
+	if err != nil {
+		return result, err
+	}
+
+	if len(target.Folders) > 0 {
+		name := params.Workspace
+		if name == "" {
+			parts := []string{session.Hostname}
+			for _, folder := range target.Folders {
+				parts = append(parts, path.Base(folder))
+			}
+			name = config.WorkspaceName(parts...)
+		}
+
+		target.WorkspaceFile, err = editor.WriteWorkspace(name, target)
+		if err != nil {
+			return result, err
+		}
+		slog.Info("workspace written", "file", target.WorkspaceFile)
+	}
+
+	bin, args := editor.Command(target)
+	cmd := exec.Command(bin, args...)
+	if editor.Detached() {
+		err = cmd.Start()
+		if err == nil {
+			go cmd.Wait()
+		}
+
+		return result, err
+	}
+
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if params.Wait && errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+		return result, nil
+	}
+
+	return result, err
+}
+
+// localPath resolves a path of this machine given by remote gcode, relative
+// paths are relative to the home directory. A remote session may only open
+// regular files in the home directory outside of hidden directories, so
+// e.g. ~/.ssh can't be read through a diff.
+func localPath(file string) (string, error) {
+	if file == "" {
+		return "", nil
 	}
 
-	session, ok := h.sessions[params.Sid]
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	if file == "~" || strings.HasPrefix(file, "~/") {
+		file = home + file[1:]
+	}
+
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(home, file)
+	}
+
+	resolved, err := filepath.EvalSymlinks(filepath.Clean(file))
+	if err != nil {
+		return "", fmt.Errorf("local file: %w", err)
+	}
+
+	if realHome, err := filepath.EvalSymlinks(home); err == nil {
+		home = realHome
+	}
+
+	rel, err := filepath.Rel(home, resolved)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("local file must be in the home directory: %s", file)
+	}
+
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if strings.HasPrefix(part, ".") {
+			return "", fmt.Errorf("local file must not be hidden: %s", file)
+		}
+	}
+
+	if stat, err := os.Stat(resolved); err != nil || !stat.Mode().IsRegular() {
+		return "", fmt.Errorf("local file is not a regular file: %s", file)
+	}
+
+	return resolved, nil
+}
+
+// SSHOptions returns the options to connect to the host of the session, the
+// connection of the ssh master is reused if there is one.
+func (s *Session) SSHOptions() []string {
+	options := slices.Clone(s.sshOptions)
+	if s.controlPath != "" {
+		options = append(options, "-S", s.controlPath, "-o", "ControlMaster=no")
+	}
+
+	return options
+}
+
+func (h *MessageHandler) getSession(sid string, skey string) (*Session, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	session, ok := h.sessions[sid]
 	if !ok {
-		return "", fmt.Errorf("invalid sid")
+		return nil, fmt.Errorf("invalid sid")
+	}
+
+	// the ipc port may be reachable by other users on the remote host once
+	// it is forwarded over tcp, so the session key must be checked as well.
+	if session.skey != skey {
+		return nil, fmt.Errorf("invalid skey")
+	}
+
+	return session, nil
+}
+
+// Forward adds, removes or lists local port forwards of a session. Forwards
+// are managed with `ssh -O` on the control socket of the session.
+func (h *MessageHandler) Forward(params *models.ForwardParams) ([]string, error) {
+	session, err := h.getSession(params.Sid, params.Skey)
+	if err != nil {
+		return nil, err
 	}
 
-	log.Printf("bin: %s, path: %s, hostname: %s\n", params.Bin, params.Path, session.Hostname)
+	// ssh may hang, the lock is only held to read and update the forwards
+	h.lock.Lock()
+	forwards := slices.Clone(session.forwards)
+	h.lock.Unlock()
 
-	binName := params.Bin
-	hostname := session.Hostname
-	path := params.Path
+	if params.Action == "list" {
+		return forwards, nil
+	}
 
-	ssh_remote := fmt.Sprintf("vscode-remote://ssh-remote+%s%s", hostname, path)
-	cmd := exec.Command(binName, "--folder-uri", ssh_remote)
+	if session.controlPath == "" {
+		return nil, fmt.Errorf("session has no control socket, start gssh with -control")
+	}
+
+	local, remote, ok := strings.Cut(params.Port, ":")
+	if !ok {
+		remote = local
+	}
+
+	ports := make([]int, 0, 2)
+	for _, port := range []string{local, remote} {
+		value, err := strconv.Atoi(port)
+		if err != nil || value < 1 || value > 65535 {
+			return nil, fmt.Errorf("invalid port: %s", port)
+		}
+		ports = append(ports, value)
+	}
+
+	spec := fmt.Sprintf("%d:localhost:%d", ports[0], ports[1])
+	index := slices.Index(forwards, spec)
+
+	var op string
+	switch params.Action {
+	case "add":
+		if index != -1 {
+			return forwards, nil
+		}
+		op = "forward"
+	case "rm":
+		if index == -1 {
+			return nil, fmt.Errorf("no such forward: %s", params.Port)
+		}
+		op = "cancel"
+	default:
+		return nil, fmt.Errorf("unknown action: %s", params.Action)
+	}
+
+	output, err := exec.Command(
+		"ssh", "-S", session.controlPath, "-O", op, "-L", spec, session.Hostname,
+	).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("ssh -O %s failed: %s", op, strings.TrimSpace(string(output)))
+	}
+
+	slog.Info("forward", "action", params.Action, "spec", spec, "hostname", session.Hostname)
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	// the forwards may have changed while ssh was running
+	index = slices.Index(session.forwards, spec)
+	if op == "forward" && index == -1 {
+		session.forwards = append(session.forwards, spec)
+	} else if op == "cancel" && index != -1 {
+		session.forwards = slices.Delete(session.forwards, index, index+1)
+	}
+
+	return slices.Clone(session.forwards), nil
+}
+
+// Sessions returns a snapshot of the current sessions.
+func (h *MessageHandler) Sessions() map[string]*Session {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	sessions := make(map[string]*Session, len(h.sessions))
+	for sid, session := range h.sessions {
+		sessions[sid] = session
+	}
 
-	return "", cmd.Run()
+	return sessions
 }
 
 func (h *MessageHandler) DestroySession(sid string) {
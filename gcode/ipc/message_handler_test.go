@@ -0,0 +1,53 @@
+package ipc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/xingty/rcode-go/pkg/models"
+)
+
+func TestValidateDestination(t *testing.T) {
+	tests := []struct {
+		hostname string
+		user     string
+		port     string
+		valid    bool
+	}{
+		{"devbox", "", "", true},
+		{"devbox.example.com", "me", "22", true},
+		{"10.0.0.2", "deploy_user", "2200", true},
+		{"::1", "", "65535", true},
+		{"x\nProxyCommand touch /tmp/pwned", "", "", false},
+		{"-oProxyCommand=touch", "", "", false},
+		{"devbox", "me\nProxyCommand touch", "", false},
+		{"devbox", "me user", "", false},
+		{"devbox", "", "22\nProxyCommand touch", false},
+		{"devbox", "", "0", false},
+		{"devbox", "", "65536", false},
+		{"", "", "", false},
+	}
+
+	for _, test := range tests {
+		err := validateDestination(&models.NestedSessionParams{
+			Hostname: test.hostname,
+			User:     test.user,
+			Port:     test.port,
+		})
+		if (err == nil) != test.valid {
+			t.Errorf("validateDestination(%q, %q, %q) = %v, want valid %v", test.hostname, test.user, test.port, err, test.valid)
+		}
+	}
+}
+
+func TestForwardRejectsInvalidPorts(t *testing.T) {
+	h := NewMessageHandler()
+	h.sessions["sid"] = &Session{Hostname: "devbox", skey: "skey", controlPath: "/nonexistent"}
+
+	for _, port := range []string{"0", "65536", "-1", "8080:0", "0:8080", "x", "8080:", ""} {
+		_, err := h.Forward(&models.ForwardParams{Sid: "sid", Skey: "skey", Action: "add", Port: port})
+		if err == nil || !strings.Contains(err.Error(), "invalid port") {
+			t.Errorf("Forward(%q) = %v, want invalid port", port, err)
+		}
+	}
+}
@@ -4,23 +4,28 @@
 package ipc
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"os/signal"
 	"syscall"
+
+	"golang.org/x/sys/unix"
 )
 
-func StartIPCServer(binName string, args []string) error {
+// StartIPCServer starts gssh-ipc in the background and waits until it is
+// ready to accept connections. It returns the address the server listens on.
+func StartIPCServer(binName string, args []string) (string, error) {
 	cmd := exec.Command(binName, args...)
-
-	cmd.Stdout = nil
 	cmd.Stderr = nil
 
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Setsid: true,
 	}
 
-	return cmd.Start()
+	return startAndWait(cmd)
 }
 
 func StartSSHClient(args []string) error {
@@ -33,3 +38,42 @@ func StartSSHClient(args []string) error {
 	newArgs := append([]string{"ssh"}, args...)
 	return syscall.Exec(path, newArgs, os.Environ())
 }
+
+// RunSSHClient runs ssh as a child process and waits for it to exit.
+// Unlike StartSSHClient, the caller keeps running and gets the exit code of ssh.
+func RunSSHClient(args []string, stderr io.Writer) (int, error) {
+	cmd := exec.Command("ssh", args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = stderr
+
+	// the terminal delivers these to the whole foreground process group,
+	// leave them to ssh.
+	signal.Ignore(syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTSTP)
+	defer signal.Reset(syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTSTP)
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+
+	if err != nil {
+		return -1, err
+	}
+
+	return 0, nil
+}
+
+// discardStdout points stdout to os.DevNull. Unlike closing it, fd 1 stays
+// taken, so it isn't reused by a file or a connection that stray writes to
+// stdout would then corrupt.
+func discardStdout() error {
+	devnull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer devnull.Close()
+
+	return unix.Dup2(int(devnull.Fd()), int(os.Stdout.Fd()))
+}
@@ -4,22 +4,26 @@
 package ipc
 
 import (
+	"errors"
+	"io"
 	"os"
 	"os/exec"
 	"syscall"
+
+	"golang.org/x/sys/windows"
 )
 
-func StartIPCServer(binName string, args []string) error {
+// StartIPCServer starts gssh-ipc in the background and waits until it is
+// ready to accept connections. It returns the address the server listens on.
+func StartIPCServer(binName string, args []string) (string, error) {
 	cmd := exec.Command(binName, args...)
-
-	cmd.Stdout = nil
 	cmd.Stderr = nil
 
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		HideWindow: true,
 	}
 
-	return cmd.Start()
+	return startAndWait(cmd)
 }
 
 func StartSSHClient(args []string) error {
@@ -30,3 +34,41 @@ func StartSSHClient(args []string) error {
 
 	return cmd.Run()
 }
+
+func RunSSHClient(args []string, stderr io.Writer) (int, error) {
+	cmd := exec.Command("ssh", args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+
+	if err != nil {
+		return -1, err
+	}
+
+	return 0, nil
+}
+
+// discardStdout points stdout to os.DevNull, so stray writes don't fail once
+// the reader has gone away.
+func discardStdout() error {
+	devnull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	err = windows.SetStdHandle(windows.STD_OUTPUT_HANDLE, windows.Handle(devnull.Fd()))
+	if err != nil {
+		devnull.Close()
+		return err
+	}
+
+	stdout := os.Stdout
+	os.Stdout = devnull
+	return stdout.Close()
+}
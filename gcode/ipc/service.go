@@ -0,0 +1,116 @@
+package ipc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/xingty/rcode-go/gcode/config"
+)
+
+const SERVICE_NAME = "gssh-ipc"
+
+const SOCKET_UNIT = `[Unit]
+Description=gssh IPC server socket
+
+[Socket]
+ListenStream=%s
+
+[Install]
+WantedBy=sockets.target
+`
+
+const SERVICE_UNIT = `[Unit]
+Description=gssh IPC server
+Requires=%s.socket
+
+[Service]
+ExecStart=%s
+Restart=on-failure
+
+[Install]
+WantedBy=default.target
+`
+
+func socketUnitFile() string {
+	return filepath.Join(config.HOME, ".config", "systemd", "user", SERVICE_NAME+".socket")
+}
+
+// ServiceEndpoint returns the endpoint of the installed socket unit. gssh
+// connects to it when no server is running, systemd then starts gssh-ipc.
+func ServiceEndpoint() (*Endpoint, bool) {
+	content, err := os.ReadFile(socketUnitFile())
+	if err != nil {
+		return nil, false
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		addr, ok := strings.CutPrefix(strings.TrimSpace(line), "ListenStream=")
+		if !ok {
+			continue
+		}
+
+		if strings.HasPrefix(addr, "/") {
+			return &Endpoint{Network: "unix", Addr: addr}, true
+		}
+
+		return &Endpoint{Network: "tcp", Addr: addr}, true
+	}
+
+	return nil, false
+}
+
+// InstallService writes a systemd user service and socket unit for
+// gssh-ipc. systemd starts gssh-ipc on the first connection and passes it
+// the listening socket.
+func InstallService(host string, port int) error {
+	if runtime.GOOS != "linux" {
+		return errors.New("systemd services are only supported on linux")
+	}
+
+	if !IsLoopbackHost(host) {
+		return fmt.Errorf("refusing to listen on non-loopback address %s", host)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return err
+	}
+
+	unitDir := filepath.Dir(socketUnitFile())
+	err = os.MkdirAll(unitDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(port))
+	units := map[string]string{
+		SERVICE_NAME + ".socket":  fmt.Sprintf(SOCKET_UNIT, addr),
+		SERVICE_NAME + ".service": fmt.Sprintf(SERVICE_UNIT, SERVICE_NAME, exe),
+	}
+
+	for name, content := range units {
+		file := filepath.Join(unitDir, name)
+		err = os.WriteFile(file, []byte(content), 0644)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("written %s\n", file)
+	}
+
+	fmt.Println("\nenable it with:")
+	fmt.Println("  systemctl --user daemon-reload")
+	fmt.Printf("  systemctl --user enable --now %s.socket\n", SERVICE_NAME)
+	return nil
+}
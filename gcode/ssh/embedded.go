@@ -0,0 +1,30 @@
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/xingty/rcode-go/gcode/config"
+	"github.com/xingty/rcode-go/gcode/ipc"
+)
+
+// startEmbeddedServer serves the IPC protocol in-process on a private
+// loopback port, so no gssh-ipc daemon is needed. The server lives as long
+// as gssh, which stays the parent of ssh.
+func startEmbeddedServer(opts *Options) (*ipc.IPCServerSocket, error) {
+	listener, err := ipc.Listen(DEFAULT_IPC_HOST, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrStartServer, err)
+	}
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	opts.Host = DEFAULT_IPC_HOST
+	opts.Port, _ = strconv.Atoi(port)
+
+	server := ipc.NewIPCServerSocket(config.IdlePolicy{})
+	server.Reload()
+	go server.ServePrivate(listener)
+
+	return server, nil
+}
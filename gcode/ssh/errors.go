@@ -0,0 +1,13 @@
+package ssh
+
+import "errors"
+
+var (
+	ErrNoHost         = errors.New("host not found")
+	ErrKeyfile        = errors.New("failed to read keyfile")
+	ErrStartServer    = errors.New("failed to start ipc server")
+	ErrIPCUnavailable = errors.New("ipc server unavailable")
+	ErrProtocol       = errors.New("invalid response from ipc server")
+	ErrRejected       = errors.New("session rejected by ipc server")
+	ErrNestedSession  = errors.New("failed to relay session to outer gssh")
+)
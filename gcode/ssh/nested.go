@@ -0,0 +1,69 @@
+package ssh
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/xingty/rcode-go/gcode/config"
+	"github.com/xingty/rcode-go/gcode/ipc"
+	"github.com/xingty/rcode-go/pkg/models"
+)
+
+// IsNestedSession reports whether gssh runs inside another gssh session.
+func IsNestedSession() bool {
+	return os.Getenv(config.ENV_RSSH_SID) != "" && os.Getenv(config.ENV_RSSH_SKEY) != ""
+}
+
+// parseDestination extracts the hostname, user and port of the destination
+// from the ssh arguments. index is the position of the destination.
+func parseDestination(ssh_args []string, index int) (string, string, string) {
+	hostname := ssh_args[index]
+	user := ""
+	port := ""
+
+	if i := strings.LastIndex(hostname, "@"); i != -1 {
+		user = hostname[:i]
+		hostname = hostname[i+1:]
+	}
+
+	for flag, value := range sshOptions(ssh_args[:index]) {
+		if flag == 'p' {
+			port = value
+		} else if flag == 'l' && user == "" {
+			user = value
+		}
+	}
+
+	return hostname, user, port
+}
+
+// createNestedSession relays new_session to the gssh-ipc of the outer
+// session through its forwarded endpoint. It returns the new session and
+// the endpoint the nested reverse forward should point to.
+func createNestedSession(ssh_args []string, index int) (models.SessionData, string, error) {
+	sid := os.Getenv(config.ENV_RSSH_SID)
+	skey := os.Getenv(config.ENV_RSSH_SKEY)
+	hostname, user, port := parseDestination(ssh_args, index)
+
+	network, addr := ipc.SessionAddr(sid)
+	data, err := ipc.Call(network, addr, "nested_session", models.NestedSessionParams{
+		Sid:      sid,
+		Skey:     skey,
+		Hostname: hostname,
+		User:     user,
+		Port:     port,
+	})
+	if err != nil {
+		return models.SessionData{}, "", fmt.Errorf("%w: %w", ErrNestedSession, err)
+	}
+
+	session := models.SessionData{}
+	err = json.Unmarshal(data, &session)
+	if err != nil {
+		return models.SessionData{}, "", fmt.Errorf("%w: %w", ErrProtocol, err)
+	}
+
+	return session, addr, nil
+}
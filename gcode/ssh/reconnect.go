@@ -0,0 +1,90 @@
+package ssh
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/xingty/rcode-go/gcode/ipc"
+)
+
+const MAX_BACKOFF = time.Minute
+
+// ssh exits with 255 when the connection fails or drops
+const SSH_CONNECTION_LOST = 255
+
+// ssh running shorter than this never got a working connection, e.g. the
+// authentication failed or the host key didn't match
+const MIN_CONNECTED_TIME = 10 * time.Second
+
+// consecutive attempts that fail to connect before gssh gives up
+const MAX_CONNECT_FAILURES = 10
+
+// runSupervised runs ssh as a child and reconnects with backoff whenever the
+// connection is lost. The session is kept, so shells that survive on the
+// remote host (e.g. in tmux) keep working with gcode after reconnecting.
+// A first connection that fails quickly isn't retried, nor are more than
+// MAX_CONNECT_FAILURES attempts in a row that fail to connect.
+func runSupervised(opts *Options, cmd *sshCommand) (int, error) {
+	mode := opts.Forward
+	backoff := time.Second
+	failures := 0
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && mode != FORWARD_TCP {
+			cmd.removeRemoteSocket()
+		}
+
+		started := time.Now()
+		var code int
+		var err error
+		if mode == FORWARD_AUTO {
+			var used string
+			code, used, err = runAuto(opts, cmd)
+			if used == FORWARD_TCP {
+				// remote shells were told to use the tcp port, keep it
+				mode = FORWARD_TCP
+			}
+		} else if mode == FORWARD_TCP {
+			code, err = runTCP(opts, cmd)
+		} else {
+			code, err = ipc.RunSSHClient(cmd.args(opts, mode, false), os.Stderr)
+		}
+
+		if err != nil || code != SSH_CONNECTION_LOST {
+			return code, err
+		}
+
+		elapsed := time.Since(started)
+		if elapsed >= MIN_CONNECTED_TIME {
+			failures = 0
+		} else if attempt == 0 {
+			return code, nil
+		} else if failures++; failures >= MAX_CONNECT_FAILURES {
+			slog.Warn("giving up reconnecting", "failures", failures)
+			fmt.Printf("\r\ngssh: giving up after %d failed attempts\r\n", failures)
+			return code, nil
+		}
+
+		if elapsed > MAX_BACKOFF {
+			backoff = time.Second
+		}
+
+		slog.Info("connection lost, reconnecting", "backoff", backoff)
+		fmt.Printf("\r\ngssh: connection lost, reconnecting in %s...\r\n", backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, MAX_BACKOFF)
+	}
+}
+
+// removeRemoteSocket removes the socket left by the previous connection,
+// sshd refuses to forward to an existing path unless StreamLocalBindUnlink
+// is enabled on the server.
+func (c *sshCommand) removeRemoteSocket() {
+	args := connectionOptions(c.pre)
+	args = append(args, "-o", "ConnectTimeout=10", c.post[0])
+	args = append(args, "rm -f "+ipc.SessionSocket(c.session.Sid))
+
+	ipc.RunSSHClient(args, nil)
+}
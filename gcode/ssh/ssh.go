@@ -1,9 +1,16 @@
 package ssh
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -13,96 +20,315 @@ import (
 	"github.com/xingty/rcode-go/pkg/models"
 )
 
-func connect2IPCServer(ipc_host string, ipc_port int) *ipc.IPCClientSocket {
-	addr := ipc_host + ":" + strconv.Itoa(ipc_port)
+const (
+	FORWARD_AUTO = "auto"
+	FORWARD_UNIX = "unix"
+	FORWARD_TCP  = "tcp"
+)
+
+// random remote ports tried in tcp mode before giving up
+const TCP_PORT_ATTEMPTS = 3
+
+const DEFAULT_IPC_HOST = "127.0.0.1"
+const DEFAULT_IPC_PORT = 7532
+
+type Options struct {
+	// Host and Port of the local IPC server, discovered from the runtime
+	// file of gssh-ipc when both are empty
+	Host string
+	Port int
+	// Forward is one of FORWARD_AUTO, FORWARD_UNIX or FORWARD_TCP
+	Forward string
+	// RemotePort is the loopback port used on the remote host in tcp mode,
+	// a random port is picked if it is 0
+	RemotePort int
+	// Control runs ssh as a ControlMaster so gssh-ipc can add port forwards
+	// to the established connection
+	Control bool
+	// Reconnect keeps gssh running as the parent of ssh and reconnects
+	// with the same session when the connection drops
+	Reconnect bool
+	// Strict fails instead of falling back to plain ssh when the session
+	// can't be set up
+	Strict bool
+	// Embedded serves the IPC protocol in gssh itself instead of the shared
+	// gssh-ipc daemon
+	Embedded bool
+}
+
+type sshCommand struct {
+	pre     []string
+	post    []string
+	pseudo  bool
+	session models.SessionData
+	// target is the endpoint the reverse forward points to, either
+	// host:port of the IPC server or the forwarded endpoint of the outer
+	// session when gssh is nested. IPv6 hosts are already in brackets,
+	// which ssh -R accepts
+	target      string
+	controlPath string
+}
+
+// explicitAddr returns the IPC server address given with -host/-port, or
+// an empty string if it should be discovered from the runtime file.
+func (opts *Options) explicitAddr() string {
+	if opts.Host == "" && opts.Port == 0 {
+		return ""
+	}
+
+	host := opts.Host
+	if host == "" {
+		host = DEFAULT_IPC_HOST
+	}
+
+	port := opts.Port
+	if port == 0 {
+		port = DEFAULT_IPC_PORT
+	}
+
+	return net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(port))
+}
+
+func connectRunning(opts *Options) (*ipc.IPCClientSocket, string) {
+	network, addr := "tcp", opts.explicitAddr()
+	if addr == "" {
+		endpoint, err := ipc.ReadEndpoint()
+		if err != nil {
+			// no server is running, systemd starts one on connect if the
+			// socket unit is installed
+			var ok bool
+			endpoint, ok = ipc.ServiceEndpoint()
+			if !ok {
+				return nil, ""
+			}
+		}
+
+		network, addr = endpoint.Network, endpoint.Addr
+	}
+
 	sock := ipc.NewIPCClientSocket(addr)
-	err := sock.Connect("tcp")
-	if err == nil {
-		return sock
+	if sock.Connect(network) != nil {
+		return nil, ""
 	}
 
-	fmt.Println("starting ipc server...")
-	args := []string{"-host", ipc_host, "-port", strconv.Itoa(ipc_port)}
-	err = ipc.StartIPCServer("gssh-ipc", args)
+	return sock, addr
+}
+
+// connect2IPCServer connects to the running gssh-ipc, starting it if needed.
+// It returns the connection and the address of the server.
+func connect2IPCServer(opts *Options) (*ipc.IPCClientSocket, string, error) {
+	if sock, addr := connectRunning(opts); sock != nil {
+		return sock, addr, nil
+	}
+
+	// only one gssh starts the server, the others wait on the lock and
+	// connect to the server started by the winner
+	lock, err := ipc.Lock(config.GSSH_IPC_START_LOCK, true)
 	if err != nil {
-		panic(err)
+		return nil, "", fmt.Errorf("%w: %w", ErrStartServer, err)
 	}
-	time.Sleep(100 * time.Millisecond)
+	defer lock.Unlock()
 
-	for i := 1; i < 10; i++ {
-		sock = ipc.NewIPCClientSocket(addr)
-		err := sock.Connect("tcp")
-		if err == nil {
-			break
-		}
+	if sock, addr := connectRunning(opts); sock != nil {
+		return sock, addr, nil
+	}
 
-		time.Sleep(100 * time.Millisecond)
+	fmt.Println("starting ipc server...")
+	slog.Info("starting ipc server")
+	args := make([]string, 0)
+	if addr := opts.explicitAddr(); addr != "" {
+		host, port, _ := net.SplitHostPort(addr)
+		args = append(args, "-host", host, "-port", port)
 	}
 
-	return sock
+	addr, err := ipc.StartIPCServer("gssh-ipc", args)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %w", ErrStartServer, err)
+	}
+	slog.Info("ipc server started", "addr", addr)
+
+	sock := ipc.NewIPCClientSocket(addr)
+	err = sock.Connect("tcp")
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %s", ErrIPCUnavailable, addr)
+	}
+
+	return sock, addr, nil
 }
 
-func createSession(sock *ipc.IPCClientSocket, hostname string) models.SessionData {
+func readKeyfile() (string, error) {
 	data, err := os.ReadFile(config.RSSH_KEY_FILE)
 	if err != nil {
 		data, err = os.ReadFile(config.GCODE_KEY_FILE)
 		if err != nil {
-			panic(err)
+			return "", fmt.Errorf("%w: %w", ErrKeyfile, err)
 		}
 	}
 
+	return string(data), nil
+}
+
+// timeout of a single request to the IPC server
+const IPC_TIMEOUT = 10 * time.Second
+
+func createSession(sock *ipc.IPCClientSocket, hostname string, controlPath string, sshOptions []string) (models.SessionData, error) {
+	key, err := readKeyfile()
+	if err != nil {
+		return models.SessionData{}, err
+	}
+
 	session := models.SessionPayload[models.SessionParams]{
 		Method: "new_session",
 		Params: models.SessionParams{
-			Pid:      int32(os.Getpid()),
-			Hostname: hostname,
-			Keyfile:  string(data),
+			Pid:         int32(os.Getpid()),
+			Hostname:    hostname,
+			Keyfile:     key,
+			ControlPath: controlPath,
+			SSHOptions:  sshOptions,
 		},
 	}
 
 	jsondata, err := json.Marshal(session)
 	if err != nil {
-		panic(err)
+		return models.SessionData{}, err
 	}
 
+	sock.SetDeadline(time.Now().Add(IPC_TIMEOUT))
 	err = sock.Send(jsondata)
 	if err != nil {
-		panic(err)
+		return models.SessionData{}, fmt.Errorf("%w: %w", ErrIPCUnavailable, err)
 	}
 
 	response, err := sock.Receive()
 	if err != nil {
-		panic(err)
+		return models.SessionData{}, fmt.Errorf("%w: %w", ErrIPCUnavailable, err)
+	}
+
+	res := models.ResponsePayload[json.RawMessage]{}
+	err = json.Unmarshal(response, &res)
+	if err != nil {
+		return models.SessionData{}, fmt.Errorf("%w: %w", ErrProtocol, err)
 	}
 
-	res := models.ResponsePayload[models.SessionData]{}
-	json.Unmarshal(response, &res)
+	if res.Code != 0 {
+		return models.SessionData{}, fmt.Errorf("%w: %s", ErrRejected, res.Message)
+	}
 
-	return res.Data
+	data := models.SessionData{}
+	err = json.Unmarshal(res.Data, &data)
+	if err != nil || data.Sid == "" {
+		return models.SessionData{}, fmt.Errorf("%w: bad session data", ErrProtocol)
+	}
+
+	slog.Info("session created", "sid", data.Sid, "hostname", hostname)
+	return data, nil
 }
 
-func findHostPos(args []string) int {
-	for i, arg := range args {
-		if !strings.HasPrefix(arg, "-") {
+// ssh options that take an argument
+const SSH_ARG_FLAGS = "BbcDEeFIiJLlmOopQRSWw"
+
+// scanOptions walks the ssh options in args and calls fn for each flag that
+// takes an argument. It returns the index of the first non-option argument,
+// or -1 if there is none.
+func scanOptions(args []string, fn func(flag byte, value string)) int {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
 			return i
 		}
+
+		if arg == "--" {
+			if i+1 < len(args) {
+				return i + 1
+			}
+			return -1
+		}
+
+		for j := 1; j < len(arg); j++ {
+			if !strings.ContainsRune(SSH_ARG_FLAGS, rune(arg[j])) {
+				continue
+			}
+
+			value := arg[j+1:]
+			if value == "" && i+1 < len(args) {
+				i++
+				value = args[i]
+			}
+
+			fn(arg[j], value)
+			break
+		}
 	}
 
 	return -1
 }
 
-func createSSHArgs(
-	host string,
-	port int,
-	ssh_args []string) []string {
+// sshOptions returns the value of each ssh flag that takes an argument,
+// the last one wins when a flag is repeated.
+func sshOptions(args []string) map[byte]string {
+	options := make(map[byte]string)
+	scanOptions(args, func(flag byte, value string) {
+		options[flag] = value
+	})
+
+	return options
+}
+
+// ssh flags that belong to the interactive session only, they aren't passed
+// on to other connections to the host
+const SSH_SESSION_FLAGS = "DfLMNRSsTtWw"
+
+// connectionOptions returns the options of pre that are needed to connect
+// to the host again, e.g. -p, -i, -J and -o. Combined flags such as -tp 22
+// are walked like scanOptions does and returned one by one.
+func connectionOptions(pre []string) []string {
+	options := make([]string, 0)
+	for i := 0; i < len(pre); i++ {
+		arg := pre[i]
+		if len(arg) < 2 || arg[0] != '-' || arg == "--" {
+			continue
+		}
+
+		for j := 1; j < len(arg); j++ {
+			flag := rune(arg[j])
+			option := []string{"-" + string(flag)}
+			hasValue := strings.ContainsRune(SSH_ARG_FLAGS, flag)
+			if hasValue {
+				value := arg[j+1:]
+				if value == "" && i+1 < len(pre) {
+					i++
+					value = pre[i]
+				}
+				option = append(option, value)
+			}
+
+			if !strings.ContainsRune(SSH_SESSION_FLAGS, flag) {
+				options = append(options, option...)
+			}
+
+			if hasValue {
+				break
+			}
+		}
+	}
 
+	return options
+}
+
+func findHostPos(args []string) int {
+	return scanOptions(args, func(byte, string) {})
+}
+
+// newSSHCommand creates a session for ssh_args. It returns nil without an
+// error if gssh should step aside for plain ssh.
+func newSSHCommand(opts *Options, ssh_args []string) (*sshCommand, error) {
 	pseudo := false
 	for i := range ssh_args {
 		param := ssh_args[i]
 		if param == "-R" || param == "-T" {
 			fmt.Println("Warning: gssh is disabled because of -R or -T")
 			fmt.Println("ssh is used instead")
-			return ssh_args
+			return nil, nil
 		}
 
 		if param == "-t" {
@@ -112,34 +338,218 @@ func createSSHArgs(
 
 	index := findHostPos(ssh_args)
 	if index == -1 {
-		fmt.Println("Error: host not found")
-		os.Exit(1)
+		return nil, ErrNoHost
+	}
+
+	cmd := &sshCommand{
+		pre:    ssh_args[:index],
+		post:   ssh_args[index:],
+		pseudo: pseudo,
+	}
+
+	if IsNestedSession() {
+		s, target, err := createNestedSession(ssh_args, index)
+		if err != nil {
+			return nil, err
+		}
+
+		cmd.session = s
+		cmd.target = target
+		return cmd, nil
+	}
+
+	if opts.Control {
+		cmd.controlPath = filepath.Join(config.GCODE_RUN_DIR, fmt.Sprintf("cm-%d.sock", os.Getpid()))
+		os.Remove(cmd.controlPath)
 	}
 
-	pre := ssh_args[:index]
-	post := ssh_args[index:]
 	hostname := ssh_args[index]
+	socks, addr, err := connect2IPCServer(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer socks.Close()
 
-	socks := connect2IPCServer(host, port)
-	s := createSession(socks, hostname)
-	socks.Close()
+	cmd.session, err = createSession(socks, hostname, cmd.controlPath, connectionOptions(cmd.pre))
+	if err != nil {
+		return nil, err
+	}
+
+	cmd.target = addr
+	return cmd, nil
+}
 
+// args builds the ssh arguments for the given forward mode. With
+// exitOnFailure ssh exits with 255 if the reverse forward is refused by the
+// server, which lets the caller retry in another mode.
+func (c *sshCommand) args(opts *Options, mode string, exitOnFailure bool) []string {
 	buf := make([]string, 0)
-	buf = append(buf, pre...)
-	if !pseudo {
+	buf = append(buf, c.pre...)
+	if !c.pseudo {
 		buf = append(buf, "-t")
 	}
 
-	sock := fmt.Sprintf("/tmp/rssh-ipc-%s.sock", s.Sid)
-	tunnel := fmt.Sprintf("%s:%s:%d", sock, host, port)
-	buf = append(buf, "-R", tunnel)
-	buf = append(buf, post...)
-	env := fmt.Sprintf("export RSSH_SID=%s; export RSSH_SKEY=%s; exec $SHELL", s.Sid, s.Key)
+	if exitOnFailure {
+		buf = append(buf, "-o", "ExitOnForwardFailure=yes")
+	}
+
+	if c.controlPath != "" {
+		buf = append(buf,
+			"-o", "ControlMaster=yes",
+			"-o", "ControlPath="+c.controlPath,
+			"-o", "ControlPersist=no",
+		)
+	}
+
+	env := fmt.Sprintf("export RSSH_SID=%s; export RSSH_SKEY=%s;", c.session.Sid, c.session.Key)
+	if mode == FORWARD_TCP {
+		if opts.RemotePort == 0 {
+			opts.RemotePort = randomRemotePort()
+		}
+
+		tunnel := fmt.Sprintf("127.0.0.1:%d:%s", opts.RemotePort, c.target)
+		buf = append(buf, "-R", tunnel)
+		env += fmt.Sprintf(" export RSSH_ADDR=tcp://127.0.0.1:%d;", opts.RemotePort)
+	} else {
+		sock := ipc.SessionSocket(c.session.Sid)
+		tunnel := fmt.Sprintf("%s:%s", sock, c.target)
+		buf = append(buf, "-R", tunnel)
+	}
+
+	buf = append(buf, c.post...)
+	return append(buf, env+" exec $SHELL")
+}
+
+func randomRemotePort() int {
+	return 20000 + rand.Intn(40000)
+}
+
+// listenAddr returns the socket path or port the reverse forward of mode
+// listens on, which ssh names when it fails to forward it.
+func (c *sshCommand) listenAddr(opts *Options, mode string) string {
+	if mode == FORWARD_TCP {
+		return strconv.Itoa(opts.RemotePort)
+	}
+
+	return ipc.SessionSocket(c.session.Sid)
+}
+
+// stderrBuffer keeps the beginning of ssh's stderr, forward failures are
+// reported right after the connection is established.
+type stderrBuffer struct {
+	buf bytes.Buffer
+}
+
+func (b *stderrBuffer) Write(p []byte) (int, error) {
+	if remain := 8192 - b.buf.Len(); remain > 0 {
+		b.buf.Write(p[:min(len(p), remain)])
+	}
+
+	return len(p), nil
+}
+
+func (b *stderrBuffer) contains(s string) bool {
+	return strings.Contains(b.buf.String(), s)
+}
+
+// runMode runs ssh as a child with ExitOnForwardFailure. failed reports
+// whether ssh exited because the reverse forward couldn't listen on the
+// remote host, detected by the listen address rather than the wording of
+// the message.
+func runMode(opts *Options, cmd *sshCommand, mode string) (int, bool, error) {
+	buf := &stderrBuffer{}
+	args := cmd.args(opts, mode, true)
+	code, err := ipc.RunSSHClient(args, io.MultiWriter(os.Stderr, buf))
+	failed := err == nil && code == SSH_CONNECTION_LOST && buf.contains(cmd.listenAddr(opts, mode))
+	return code, failed, err
+}
+
+// runTCP runs ssh in tcp mode. A random remote port that is already taken
+// is replaced by another one, a port given with -remote-port is not.
+func runTCP(opts *Options, cmd *sshCommand) (int, error) {
+	random := opts.RemotePort == 0
+	for attempt := 1; ; attempt++ {
+		if random {
+			opts.RemotePort = randomRemotePort()
+		}
+
+		code, failed, err := runMode(opts, cmd, FORWARD_TCP)
+		if !failed || !random || attempt == TCP_PORT_ATTEMPTS {
+			return code, err
+		}
+
+		fmt.Printf("Warning: remote port %d is taken, retrying with another port\n", opts.RemotePort)
+		slog.Info("remote port is taken, retrying", "port", opts.RemotePort)
+	}
+}
+
+// runAuto tries unix socket forwarding first and falls back to tcp if the
+// server refuses it. It returns the exit code of ssh and the mode used.
+func runAuto(opts *Options, cmd *sshCommand) (int, string, error) {
+	code, failed, err := runMode(opts, cmd, FORWARD_UNIX)
+	if !failed {
+		return code, FORWARD_UNIX, err
+	}
 
-	return append(buf, env)
+	fmt.Println("Warning: unix socket forwarding failed, retrying with tcp forwarding")
+	slog.Info("unix socket forwarding failed, retrying with tcp forwarding")
+	code, err = runTCP(opts, cmd)
+	return code, FORWARD_TCP, err
 }
 
-func Run(ipc_host string, ipc_port int, ssh_args []string) {
-	newArgs := createSSHArgs(ipc_host, ipc_port, ssh_args)
-	ipc.StartSSHClient(newArgs)
+func Run(opts *Options, ssh_args []string) {
+	var err error
+	var server *ipc.IPCServerSocket
+	if opts.Embedded && !IsNestedSession() {
+		server, err = startEmbeddedServer(opts)
+	}
+
+	var cmd *sshCommand
+	if err == nil {
+		cmd, err = newSSHCommand(opts, ssh_args)
+	}
+
+	if err != nil {
+		slog.Warn("failed to set up the session", "error", err, "strict", opts.Strict)
+		if opts.Strict {
+			fmt.Printf("Error: gssh: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		// without a host ssh just prints its usage, nothing to warn about
+		if !errors.Is(err, ErrNoHost) {
+			fmt.Printf("Warning: gssh: %s, continuing as plain ssh\n", err.Error())
+		}
+	}
+
+	if cmd == nil {
+		ipc.StartSSHClient(ssh_args)
+		return
+	}
+
+	var code int
+	if opts.Reconnect {
+		code, err = runSupervised(opts, cmd)
+	} else if opts.Forward == FORWARD_AUTO {
+		code, _, err = runAuto(opts, cmd)
+	} else if opts.Forward == FORWARD_TCP {
+		code, err = runTCP(opts, cmd)
+	} else if server != nil {
+		// the server lives in this process, ssh can't replace it
+		code, err = ipc.RunSSHClient(cmd.args(opts, opts.Forward, false), os.Stderr)
+	} else {
+		ipc.StartSSHClient(cmd.args(opts, opts.Forward, false))
+		return
+	}
+
+	if server != nil {
+		server.Stop()
+	}
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	os.Exit(code)
 }
@@ -0,0 +1,29 @@
+package ssh
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestConnectionOptions(t *testing.T) {
+	tests := []struct {
+		pre  []string
+		want []string
+	}{
+		{[]string{"-p", "22", "-i", "key"}, []string{"-p", "22", "-i", "key"}},
+		{[]string{"-p22", "-J", "jump"}, []string{"-p", "22", "-J", "jump"}},
+		{[]string{"-tp", "22"}, []string{"-p", "22"}},
+		{[]string{"-4i", "key"}, []string{"-4", "-i", "key"}},
+		{[]string{"-Ap2200"}, []string{"-A", "-p", "2200"}},
+		{[]string{"-N", "-L", "8080:localhost:80", "-o", "User=me"}, []string{"-o", "User=me"}},
+		{[]string{"-tt", "-R", "/tmp/s:127.0.0.1:7532"}, []string{}},
+		{[]string{"--", "-p", "22"}, []string{"-p", "22"}},
+	}
+
+	for _, test := range tests {
+		got := connectionOptions(test.pre)
+		if !slices.Equal(got, test.want) {
+			t.Errorf("connectionOptions(%q) = %q, want %q", test.pre, got, test.want)
+		}
+	}
+}
@@ -1,13 +1,27 @@
 package models
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+)
 
 var DELIMITER = byte(0x1e)
 
 type SessionParams struct {
-	Pid      int32  `json:"pid"`
+	Pid         int32  `json:"pid"`
+	Hostname    string `json:"hostname"`
+	Keyfile     string `json:"keyfile"`
+	ControlPath string `json:"control_path,omitempty"`
+	// ssh options of the connection, terminal editors connect with them
+	SSHOptions []string `json:"ssh_options,omitempty"`
+}
+
+type NestedSessionParams struct {
+	Sid      string `json:"sid"`
+	Skey     string `json:"skey"`
 	Hostname string `json:"hostname"`
-	Keyfile  string `json:"keyfile"`
+	User     string `json:"user"`
+	Port     string `json:"port"`
 }
 
 type OpenIDEParams struct {
@@ -15,6 +29,102 @@ type OpenIDEParams struct {
 	Skey string `json:"skey"`
 	Bin  string `json:"bin"`
 	Path string `json:"path"`
+	// Paths are all paths given to gcode, Path is the first one
+	Paths []string `json:"paths,omitempty"`
+	// Files are opened with --file-uri, in the window of Path if it is set
+	Files []string `json:"files,omitempty"`
+	// Folders are opened as a multi-root workspace, saved as Workspace
+	Folders   []string `json:"folders,omitempty"`
+	Workspace string   `json:"workspace,omitempty"`
+	// Local is a file on the machine of the editor, compared with Path by -d
+	Local string `json:"local,omitempty"`
+	// DevContainer reopens Path in its dev container
+	DevContainer *DevContainer `json:"devcontainer,omitempty"`
+	OpenOptions
+}
+
+// DevContainer is the dev container a remote directory is opened in.
+type DevContainer struct {
+	// path of devcontainer.json on the remote host
+	ConfigFile string `json:"config_file"`
+	// the directory inside the container
+	WorkspaceFolder string `json:"workspace_folder"`
+}
+
+type OpenIDEResult struct {
+	// exit code of the editor, only meaningful with --wait
+	ExitCode int `json:"exit_code"`
+}
+
+// OpenOptions are the options of the VS Code CLI remote gcode passes on.
+type OpenOptions struct {
+	// open Path at file:line[:col]
+	Goto bool `json:"goto,omitempty"`
+	// compare the two Paths
+	Diff bool `json:"diff,omitempty"`
+	// merge the Paths: path1 path2 base result
+	Merge       bool   `json:"merge,omitempty"`
+	NewWindow   bool   `json:"new_window,omitempty"`
+	ReuseWindow bool   `json:"reuse_window,omitempty"`
+	Add         bool   `json:"add,omitempty"`
+	Profile     string `json:"profile,omitempty"`
+	// wait for the files to be closed, the request is kept open until then
+	Wait bool `json:"wait,omitempty"`
+}
+
+// Validate checks the number of paths the options need.
+func (o OpenOptions) Validate(paths int) error {
+	switch {
+	case o.Diff && o.Merge:
+		return fmt.Errorf("-d and -m can't be used together")
+	case o.Diff && paths != 2:
+		return fmt.Errorf("-d needs 2 files")
+	case o.Merge && paths != 4:
+		return fmt.Errorf("-m needs 4 files: path1 path2 base result")
+	case o.NewWindow && o.ReuseWindow:
+		return fmt.Errorf("-n and -r can't be used together")
+	}
+
+	return nil
+}
+
+// IsZero reports whether no option is set.
+func (o OpenOptions) IsZero() bool {
+	return o == OpenOptions{}
+}
+
+type ForwardParams struct {
+	Sid    string `json:"sid"`
+	Skey   string `json:"skey"`
+	Action string `json:"action"`
+	// Port is either <port> or <local port>:<remote port>
+	Port string `json:"port"`
+}
+
+type AdminParams struct {
+	Keyfile string `json:"keyfile"`
+	Action  string `json:"action"`
+}
+
+type ServerStatus struct {
+	Pid      int    `json:"pid"`
+	Addr     string `json:"addr"`
+	Started  int64  `json:"started"`
+	Sessions int    `json:"sessions"`
+	// seconds until the server idles out, -1 if it never does
+	ShutdownIn     int64  `json:"shutdown_in"`
+	ShutdownReason string `json:"shutdown_reason"`
+	// command line arguments of the server, reused by restart
+	Args []string `json:"args"`
+}
+
+type SessionInfo struct {
+	Sid      string `json:"sid"`
+	Hostname string `json:"hostname"`
+	Pid      int32  `json:"pid"`
+	Parent   string `json:"parent,omitempty"`
+	Created  int64  `json:"created"`
+	Active   bool   `json:"active"`
 }
 
 type SessionPayload[T any] struct {
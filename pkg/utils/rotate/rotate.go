@@ -0,0 +1,141 @@
+// Package rotate provides a log file that rotates by size and prunes old
+// backups by age and count.
+package rotate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const BACKUP_TIME_FORMAT = "20060102-150405.000"
+
+type File struct {
+	Path string
+	// rotate once the file grows past MaxSize bytes, 0 never rotates
+	MaxSize int64
+	// remove backups older than MaxAge, 0 keeps them
+	MaxAge time.Duration
+	// keep at most MaxBackups backups, 0 keeps them all
+	MaxBackups int
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+}
+
+func Open(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*File, error) {
+	f := &File{
+		Path:       path,
+		MaxSize:    maxSize,
+		MaxAge:     maxAge,
+		MaxBackups: maxBackups,
+	}
+
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+
+	go f.prune()
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.MaxSize > 0 && f.size+int64(len(p)) > f.MaxSize && f.size > 0 {
+		err := f.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate moves the current file aside and starts a new one. Several
+// processes may share the file, so it is only moved if another process
+// hasn't rotated it already.
+func (f *File) rotate() error {
+	f.file.Close()
+
+	info, err := os.Stat(f.Path)
+	if err == nil && info.Size() >= f.size {
+		backup := fmt.Sprintf("%s.%s", f.Path, time.Now().Format(BACKUP_TIME_FORMAT))
+		os.Rename(f.Path, backup)
+	}
+
+	err = f.open()
+	if err != nil {
+		return err
+	}
+
+	go f.prune()
+	return nil
+}
+
+// Backups returns the rotated files, the newest first.
+func (f *File) Backups() []string {
+	matches, _ := filepath.Glob(f.Path + ".*")
+	backups := make([]string, 0, len(matches))
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(match, f.Path+".")
+		if _, err := time.Parse(BACKUP_TIME_FORMAT, suffix); err == nil {
+			backups = append(backups, match)
+		}
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	return backups
+}
+
+func (f *File) prune() {
+	now := time.Now()
+	for i, backup := range f.Backups() {
+		if f.MaxBackups > 0 && i >= f.MaxBackups {
+			os.Remove(backup)
+			continue
+		}
+
+		if f.MaxAge <= 0 {
+			continue
+		}
+
+		info, err := os.Stat(backup)
+		if err == nil && now.Sub(info.ModTime()) > f.MaxAge {
+			os.Remove(backup)
+		}
+	}
+}
+
+func (f *File) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.file.Close()
+}
@@ -1,7 +1,15 @@
 package sshconf
 
 import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
 	"slices"
+	"strconv"
+	"strings"
+	"unicode"
 
 	"github.com/mikkeloscar/sshconfig"
 )
@@ -40,3 +48,96 @@ func (s *SSHConfig) GetHost(hostname string) *SSHEndpoint {
 
 	return nil
 }
+
+// validValue reports whether value can be written as a single ssh_config
+// argument without starting another option or a comment.
+func validValue(value string) bool {
+	return !strings.ContainsFunc(value, func(r rune) bool {
+		return r == '#' || unicode.IsSpace(r) || unicode.IsControl(r)
+	})
+}
+
+// UpsertHost writes a Host block for alias into configFile, replacing any
+// existing block with the same alias. Options are written in the given order.
+func UpsertHost(configFile string, alias string, options [][2]string) error {
+	if alias == "" || !validValue(alias) {
+		return fmt.Errorf("invalid host alias: %q", alias)
+	}
+
+	for _, option := range options {
+		if !validValue(option[1]) {
+			return fmt.Errorf("invalid value of %s: %q", option[0], option[1])
+		}
+	}
+
+	content, err := os.ReadFile(configFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	lines := make([]string, 0)
+	skip := false
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && (strings.EqualFold(fields[0], "Host") || strings.EqualFold(fields[0], "Match")) {
+			skip = strings.EqualFold(fields[0], "Host") && len(fields) == 2 && fields[1] == alias
+		}
+
+		if !skip {
+			lines = append(lines, line)
+		}
+	}
+
+	var buf strings.Builder
+	if text := strings.TrimSpace(strings.Join(lines, "\n")); text != "" {
+		buf.WriteString(text)
+		buf.WriteString("\n\n")
+	}
+
+	buf.WriteString("Host " + alias + "\n")
+	for _, option := range options {
+		if option[1] != "" {
+			buf.WriteString(fmt.Sprintf("    %s %s\n", option[0], option[1]))
+		}
+	}
+
+	return os.WriteFile(configFile, []byte(buf.String()), 0644)
+}
+
+// Destination is where ssh connects to for a host, as resolved by `ssh -G`.
+type Destination struct {
+	HostName string
+	User     string
+	Port     int
+	// Proxy is the ProxyJump or ProxyCommand of the host, if any
+	Proxy string
+}
+
+// Resolve asks ssh for the effective hostname, user and port of host, so
+// aliases, Match blocks and Include files are honored.
+func Resolve(host string) (Destination, error) {
+	output, err := exec.Command("ssh", "-G", host).Output()
+	if err != nil {
+		return Destination{}, fmt.Errorf("ssh -G %s: %w", host, err)
+	}
+
+	dest := Destination{HostName: host, Port: 22}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		switch key {
+		case "hostname":
+			dest.HostName = value
+		case "user":
+			dest.User = value
+		case "port":
+			dest.Port, _ = strconv.Atoi(value)
+		case "proxyjump", "proxycommand":
+			if value != "none" {
+				dest.Proxy = value
+			}
+		}
+	}
+
+	return dest, nil
+}
@@ -0,0 +1,70 @@
+package sshconf
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUpsertHostRejectsInjection(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "ssh_config")
+
+	values := []string{
+		"x\nProxyCommand touch /tmp/pwned",
+		"x ProxyCommand=touch",
+		"x#comment",
+		"x\tUser",
+		"x\rProxyCommand touch",
+	}
+
+	for _, value := range values {
+		err := UpsertHost(configFile, "gcode-b-x", [][2]string{{"HostName", value}})
+		if err == nil {
+			t.Errorf("UpsertHost accepted %q", value)
+		}
+	}
+
+	err := UpsertHost(configFile, "gcode-b x", [][2]string{{"HostName", "x"}})
+	if err == nil {
+		t.Errorf("UpsertHost accepted an alias with a space")
+	}
+
+	if _, err := os.Stat(configFile); !os.IsNotExist(err) {
+		t.Errorf("config file was written for invalid values")
+	}
+}
+
+func TestUpsertHostKeepsMatchBlocks(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "ssh_config")
+	content := strings.Join([]string{
+		"Host gcode-b-x",
+		"    HostName old",
+		"Match host other",
+		"    User me",
+		"Host keep",
+		"    Port 2200",
+	}, "\n")
+	os.WriteFile(configFile, []byte(content), 0644)
+
+	err := UpsertHost(configFile, "gcode-b-x", [][2]string{{"HostName", "new"}, {"Port", "22"}, {"User", ""}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(configFile)
+	want := strings.Join([]string{
+		"Match host other",
+		"    User me",
+		"Host keep",
+		"    Port 2200",
+		"",
+		"Host gcode-b-x",
+		"    HostName new",
+		"    Port 22",
+		"",
+	}, "\n")
+	if string(data) != want {
+		t.Errorf("config = %q, want %q", data, want)
+	}
+}